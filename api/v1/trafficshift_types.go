@@ -0,0 +1,95 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"github.com/takutakahashi/external-route53/pkg/condition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TrafficShiftSpec defines the desired state of TrafficShift
+type TrafficShiftSpec struct {
+	// Services share a hostname, their weighted records get the weights step by step
+	Services []TrafficShiftWeight `json:"services"`
+	// StepPercent is the most weight a service gains or loses in a step, in percent of the sum of the weights of the services
+	StepPercent int `json:"stepPercent"`
+	// Interval is the time between steps
+	Interval metav1.Duration `json:"interval"`
+	// OnUnhealthy is what happens when a health check of the services is unhealthy. default: Pause
+	OnUnhealthy TrafficShiftUnhealthyPolicy `json:"onUnhealthy,omitempty"`
+}
+
+type TrafficShiftWeight struct {
+	Name   string `json:"name"`
+	Weight int    `json:"weight"`
+}
+
+type TrafficShiftUnhealthyPolicy string
+
+var UnhealthyPolicyPause TrafficShiftUnhealthyPolicy = "Pause"
+var UnhealthyPolicyRollback TrafficShiftUnhealthyPolicy = "Rollback"
+
+// TrafficShiftStatus defines the observed state of TrafficShift
+type TrafficShiftStatus struct {
+	Phase TrafficShiftPhase `json:"phase,omitempty"`
+	// Weights are the weights currently set to the services
+	Weights []TrafficShiftWeight `json:"weights,omitempty"`
+	// InitialWeights are the weights before the shift, restored by rollback
+	InitialWeights     []TrafficShiftWeight `json:"initialWeights,omitempty"`
+	Step               int                  `json:"step,omitempty"`
+	LastStepTime       *metav1.Time         `json:"lastStepTime,omitempty"`
+	ObservedGeneration int64                `json:"observedGeneration,omitempty"`
+	Message            string               `json:"message,omitempty"`
+	// Conditions has the Valid condition, False while the spec or the services can't be shifted
+	Conditions []condition.Condition `json:"conditions,omitempty"`
+}
+
+type TrafficShiftPhase string
+
+var PhaseProgressing TrafficShiftPhase = "Progressing"
+var PhasePaused TrafficShiftPhase = "Paused"
+var PhaseCompleted TrafficShiftPhase = "Completed"
+var PhaseRolledBack TrafficShiftPhase = "RolledBack"
+
+var TrafficShiftConditionValid = "Valid"
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Step",type=integer,JSONPath=`.status.step`
+
+// TrafficShift is the Schema for the trafficshifts API
+type TrafficShift struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   TrafficShiftSpec   `json:"spec,omitempty"`
+	Status TrafficShiftStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// TrafficShiftList contains a list of TrafficShift
+type TrafficShiftList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []TrafficShift `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TrafficShift{}, &TrafficShiftList{})
+}
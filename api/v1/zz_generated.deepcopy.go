@@ -22,6 +22,7 @@ limitations under the License.
 package v1
 
 import (
+	"github.com/takutakahashi/external-route53/pkg/condition"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
@@ -145,3 +146,132 @@ func (in *HealthCheckStatus) DeepCopy() *HealthCheckStatus {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShift) DeepCopyInto(out *TrafficShift) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShift.
+func (in *TrafficShift) DeepCopy() *TrafficShift {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShift)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficShift) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShiftList) DeepCopyInto(out *TrafficShiftList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]TrafficShift, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShiftList.
+func (in *TrafficShiftList) DeepCopy() *TrafficShiftList {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShiftList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *TrafficShiftList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShiftSpec) DeepCopyInto(out *TrafficShiftSpec) {
+	*out = *in
+	if in.Services != nil {
+		in, out := &in.Services, &out.Services
+		*out = make([]TrafficShiftWeight, len(*in))
+		copy(*out, *in)
+	}
+	out.Interval = in.Interval
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShiftSpec.
+func (in *TrafficShiftSpec) DeepCopy() *TrafficShiftSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShiftSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShiftStatus) DeepCopyInto(out *TrafficShiftStatus) {
+	*out = *in
+	if in.Weights != nil {
+		in, out := &in.Weights, &out.Weights
+		*out = make([]TrafficShiftWeight, len(*in))
+		copy(*out, *in)
+	}
+	if in.InitialWeights != nil {
+		in, out := &in.InitialWeights, &out.InitialWeights
+		*out = make([]TrafficShiftWeight, len(*in))
+		copy(*out, *in)
+	}
+	if in.LastStepTime != nil {
+		in, out := &in.LastStepTime, &out.LastStepTime
+		*out = (*in).DeepCopy()
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]condition.Condition, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShiftStatus.
+func (in *TrafficShiftStatus) DeepCopy() *TrafficShiftStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShiftStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *TrafficShiftWeight) DeepCopyInto(out *TrafficShiftWeight) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new TrafficShiftWeight.
+func (in *TrafficShiftWeight) DeepCopy() *TrafficShiftWeight {
+	if in == nil {
+		return nil
+	}
+	out := new(TrafficShiftWeight)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,126 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// GarbageCollector periodically deletes the records owned by services which no longer exist.
+type GarbageCollector struct {
+	client.Client
+	Log     logr.Logger
+	Route53 r53api.API
+	// Interval is the time between scans of the hosted zones
+	Interval time.Duration
+	// MinAge is how long records stay orphaned before they are deleted,
+	// so that a service just created isn't mistaken as missing
+	MinAge time.Duration
+	// DryRun logs the orphaned records instead of deleting them
+	DryRun bool
+
+	// orphanedSince is when the records of each identifier were found orphaned
+	orphanedSince map[string]time.Time
+}
+
+func (g *GarbageCollector) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+			if err := g.collect(time.Now()); err != nil {
+				g.Log.Error(err, "failed to collect orphaned records")
+			}
+		}
+	}
+}
+
+// collect deletes the records orphaned for MinAge.
+func (g *GarbageCollector) collect(now time.Time) error {
+	managed, err := dns.ListManagedRecords(g.Route53)
+	if err != nil {
+		return err
+	}
+	// left is the number of the orphaned record sets which are not deleted in this scan
+	left := 0
+	orphanedSince := map[string]time.Time{}
+	for _, m := range managed {
+		orphaned, err := g.orphaned(m)
+		if err != nil {
+			return err
+		}
+		if !orphaned {
+			continue
+		}
+		key := m.HostedZoneID + "/" + m.Identifier
+		since, ok := g.orphanedSince[key]
+		if !ok {
+			since = now
+		}
+		orphanedSince[key] = since
+		if now.Sub(since) < g.MinAge {
+			left += len(m.RecordSets)
+			continue
+		}
+		log := g.Log.WithValues("zone", m.HostedZoneID, "identifier", m.Identifier, "records", len(m.RecordSets))
+		if g.DryRun {
+			log.Info("orphaned records would be deleted (dry run)")
+			left += len(m.RecordSets)
+			continue
+		}
+		if err := dns.DeleteManagedRecords(g.Route53, m); err != nil {
+			return err
+		}
+		log.Info("deleted orphaned records")
+		metrics.OrphanedRecordsDeleted.Add(float64(len(m.RecordSets)))
+		delete(orphanedSince, key)
+	}
+	g.orphanedSince = orphanedSince
+	metrics.OrphanedRecords.Set(float64(left))
+	return nil
+}
+
+// orphaned reports whether the service of the records no longer exists.
+// A service recreated with the same name has another UID and doesn't own the records either.
+func (g *GarbageCollector) orphaned(m dns.ManagedRecords) (bool, error) {
+	svc := corev1.Service{}
+	if err := g.Get(context.TODO(), types.NamespacedName{Namespace: m.Namespace, Name: m.Name}, &svc); err != nil {
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	return string(svc.UID) != m.UID, nil
+}
+
+func (g *GarbageCollector) SetupWithManager(mgr ctrl.Manager) error {
+	return mgr.Add(g)
+}
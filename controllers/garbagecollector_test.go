@@ -0,0 +1,112 @@
+package controllers
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+func TestGarbageCollectorCollect(t *testing.T) {
+	os.Setenv("HOSTED_ZONE_ID", "Z09261522C0IVI11TUTK7")
+	defer os.Unsetenv("HOSTED_ZONE_ID")
+	service := func(name, uid string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				UID:       types.UID(uid),
+				Annotations: map[string]string{
+					dns.HostnameAnnotationKey: name + ".test.takutakahashi.dev",
+				},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:        corev1.ServiceTypeLoadBalancer,
+				ExternalIPs: []string{"10.10.10.1"},
+			},
+		}
+	}
+	tests := []struct {
+		name string
+		// services are the services in the cluster, the records are applied for the services of applied
+		services []runtime.Object
+		applied  []*corev1.Service
+		dryRun   bool
+		// want is the number of the record sets left after each collection
+		want []int
+		// wantOrphaned is the number of the orphaned record sets left after each collection
+		wantOrphaned []int
+	}{
+		{
+			name:         "existing",
+			services:     []runtime.Object{service("test", "uid")},
+			applied:      []*corev1.Service{service("test", "uid")},
+			want:         []int{2, 2},
+			wantOrphaned: []int{0, 0},
+		},
+		{
+			name:         "deleted",
+			applied:      []*corev1.Service{service("test", "uid")},
+			want:         []int{2, 0},
+			wantOrphaned: []int{2, 0},
+		},
+		{
+			name:         "recreated",
+			services:     []runtime.Object{service("test", "new-uid")},
+			applied:      []*corev1.Service{service("test", "uid")},
+			want:         []int{2, 0},
+			wantOrphaned: []int{2, 0},
+		},
+		{
+			name:         "dry run",
+			applied:      []*corev1.Service{service("test", "uid")},
+			dryRun:       true,
+			want:         []int{2, 2},
+			wantOrphaned: []int{2, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := fake.New()
+			api.AddHostedZone("Z09261522C0IVI11TUTK7", "test.takutakahashi.dev", false)
+			for _, svc := range tt.applied {
+				if err := dns.Ensure(api, svc); err != nil {
+					t.Fatal(err)
+				}
+			}
+			g := &GarbageCollector{
+				Client:  newFakeClient(tt.services...),
+				Log:     ctrl.Log.WithName("test"),
+				Route53: api,
+				MinAge:  time.Hour,
+				DryRun:  tt.dryRun,
+			}
+			// the records are deleted once they have been orphaned for MinAge
+			now := time.Now()
+			deleted := testutil.ToFloat64(metrics.OrphanedRecordsDeleted)
+			for i, at := range []time.Time{now, now.Add(time.Hour)} {
+				if err := g.collect(at); err != nil {
+					t.Fatal(err)
+				}
+				if got := len(api.RecordSets("Z09261522C0IVI11TUTK7")); got != tt.want[i] {
+					t.Errorf("collect() #%d left %d record sets, want %d", i, got, tt.want[i])
+				}
+				if got := testutil.ToFloat64(metrics.OrphanedRecords); got != float64(tt.wantOrphaned[i]) {
+					t.Errorf("collect() #%d reported %v orphaned record sets, want %d", i, got, tt.wantOrphaned[i])
+				}
+			}
+			if got := testutil.ToFloat64(metrics.OrphanedRecordsDeleted) - deleted; got != float64(tt.want[0]-tt.want[1]) {
+				t.Errorf("collect() reported %v deleted record sets, want %d", got, tt.want[0]-tt.want[1])
+			}
+		})
+	}
+}
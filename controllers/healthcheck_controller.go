@@ -29,13 +29,15 @@ import (
 
 	route53v1 "github.com/takutakahashi/external-route53/api/v1"
 	"github.com/takutakahashi/external-route53/pkg/healthcheck"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
 )
 
 // HealthCheckReconciler reconciles a HealthCheck object
 type HealthCheckReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log     logr.Logger
+	Scheme  *runtime.Scheme
+	Route53 r53api.API
 }
 
 const finalizer = "healthcheck.finalizer.external-route53.io"
@@ -77,7 +79,7 @@ func (r *HealthCheckReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 }
 
 func (r *HealthCheckReconciler) reconcile(h route53v1.HealthCheck) error {
-	newHealthCheck, err := healthcheck.Ensure(h.DeepCopy())
+	newHealthCheck, err := healthcheck.Ensure(r.Route53, h.DeepCopy())
 	if err != nil {
 		return err
 	}
@@ -91,7 +93,7 @@ func (r *HealthCheckReconciler) reconcile(h route53v1.HealthCheck) error {
 	return r.Update(context.TODO(), newHealthCheck, &client.UpdateOptions{})
 }
 func (r *HealthCheckReconciler) reconcileDelete(h route53v1.HealthCheck) error {
-	newHealthCheck, err := healthcheck.Delete(h.DeepCopy())
+	newHealthCheck, err := healthcheck.Delete(r.Route53, h.DeepCopy())
 	if err != nil {
 		return err
 	}
@@ -18,27 +18,46 @@ package controllers
 
 import (
 	"context"
+	"reflect"
+	"strings"
 	"time"
 
 	"github.com/go-logr/logr"
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
 	"github.com/takutakahashi/external-route53/pkg/dns"
 	"github.com/takutakahashi/external-route53/pkg/healthcheck"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
 )
 
 // ServiceReconciler reconciles a Service object
 type ServiceReconciler struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Route53  r53api.API
+	Recorder record.EventRecorder
+	// DrainGracePeriod is how long drained records are kept after their TTL before the deletion
+	DrainGracePeriod time.Duration
+	// ResyncPeriod is how often the records are compared with Route53 to restore the ones changed outside of the controller
+	ResyncPeriod time.Duration
+	// MaxConcurrentReconciles is how many services are reconciled at once, their changes are submitted in the same change batches
+	MaxConcurrentReconciles int
 }
 
+const serviceFinalizer = "service.finalizer.external-route53.io"
+
 // +kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=services/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
 
 func (r *ServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 	ctx := context.Background()
@@ -51,41 +70,162 @@ func (r *ServiceReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
 			return ctrl.Result{}, err
 		}
 	}
+	if !containsString(svc.Finalizers, serviceFinalizer) && svc.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
 	if svc.DeletionTimestamp != nil {
-
-		if err := r.reconcileDelete(svc.DeepCopy()); err != nil {
+		after, err := r.reconcileDelete(svc.DeepCopy())
+		if err != nil {
 			return ctrl.Result{RequeueAfter: time.Minute}, nil
 		}
-		return ctrl.Result{}, nil
+		return ctrl.Result{RequeueAfter: after}, nil
 	}
-	if err := r.reconcile(svc.DeepCopy()); err != nil {
+	after, err := r.reconcile(svc.DeepCopy())
+	if err != nil {
 		return ctrl.Result{RequeueAfter: time.Minute}, nil
 	}
 
-	return ctrl.Result{}, nil
+	return ctrl.Result{RequeueAfter: after}, nil
+}
+
+func (r *ServiceReconciler) reconcileDelete(svc *corev1.Service) (time.Duration, error) {
+	// services without the last-applied annotation have no records to delete, e.g. a load balancer never got its ingress
+	if _, ok := svc.Annotations[dns.LastAppliedAnnotationKey]; ok {
+		after, err := r.drainAndDelete(svc)
+		if err != nil || after > 0 {
+			return after, err
+		}
+	}
+	if err := r.deleteHealthCheck(svc); err != nil {
+		return 0, err
+	}
+	svc.Finalizers = removeString(svc.Finalizers, serviceFinalizer)
+	return 0, r.Update(context.TODO(), svc, &client.UpdateOptions{})
+}
+
+// drainAndDelete drains the records of the service, waits until resolvers drop them and deletes them.
+// It returns the time left to wait, the finalizer is held meanwhile.
+func (r *ServiceReconciler) drainAndDelete(svc *corev1.Service) (time.Duration, error) {
+	s, ok := svc.Annotations[dns.DrainUntilAnnotationKey]
+	if !ok {
+		ttl, err := dns.Drain(r.Route53, svc)
+		if err != nil {
+			return 0, err
+		}
+		until := time.Now().Add(ttl + r.DrainGracePeriod)
+		svc.Annotations[dns.DrainUntilAnnotationKey] = until.Format(time.RFC3339)
+		if err := r.Update(context.TODO(), svc, &client.UpdateOptions{}); err != nil {
+			return 0, err
+		}
+		return time.Until(until), nil
+	}
+	until, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return 0, err
+	}
+	if wait := time.Until(until); wait > 0 {
+		return wait, nil
+	}
+	if err := dns.Delete(r.Route53, svc); err != nil {
+		return 0, err
+	}
+	delete(svc.Annotations, dns.DrainUntilAnnotationKey)
+	return 0, nil
 }
 
-func (r *ServiceReconciler) reconcileDelete(svc *corev1.Service) error {
-	return dns.Delete(svc)
+// deleteHealthCheck removes the HealthCheck created for the service, if any.
+// Its own finalizer deletes the Route53 health check.
+func (r *ServiceReconciler) deleteHealthCheck(svc *corev1.Service) error {
+	h := route53v1.HealthCheck{}
+	nn := types.NamespacedName{Name: svc.Name, Namespace: svc.Namespace}
+	if err := r.Get(context.TODO(), nn, &h); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	for _, o := range h.OwnerReferences {
+		if o.UID == svc.UID {
+			return client.IgnoreNotFound(r.Delete(context.TODO(), &h))
+		}
+	}
+	return nil
 }
-func (r *ServiceReconciler) reconcile(svc *corev1.Service) error {
+
+func (r *ServiceReconciler) reconcile(svc *corev1.Service) (time.Duration, error) {
 	if _, ok := svc.Annotations[dns.HostnameAnnotationKey]; !ok {
-		return nil
+		// the hostname annotation was dropped, delete the applied records and release the service
+		if !containsString(svc.Finalizers, serviceFinalizer) {
+			return 0, nil
+		}
+		if _, ok := svc.Annotations[dns.LastAppliedAnnotationKey]; ok {
+			after, err := r.drainAndDelete(svc)
+			if err != nil || after > 0 {
+				return after, err
+			}
+		}
+		svc.Finalizers = removeString(svc.Finalizers, serviceFinalizer)
+		return 0, r.Update(context.TODO(), svc, &client.UpdateOptions{})
+	}
+	if !containsString(svc.Finalizers, serviceFinalizer) {
+		svc.Finalizers = append(svc.Finalizers, serviceFinalizer)
+		return 0, r.Update(context.TODO(), svc, &client.UpdateOptions{})
+	}
+	_, draining := svc.Annotations[dns.DrainUntilAnnotationKey]
+	if draining {
+		// the hostname came back while draining, Ensure restores the records
+		delete(svc.Annotations, dns.DrainUntilAnnotationKey)
 	}
 	if a, ok := svc.Annotations[dns.HealthCheckAnnotationKey]; ok && a == "true" && svc.Annotations[dns.HealthCheckIdAnnotationKey] == "" {
 		hcsvc, err := healthcheck.EnsureResource(svc)
 		if err != nil {
-			return err
+			return 0, err
 		}
 		if hcsvc != nil {
-			return r.Update(context.TODO(), hcsvc.DeepCopy(), &client.UpdateOptions{})
+			return 0, r.Update(context.TODO(), hcsvc.DeepCopy(), &client.UpdateOptions{})
 		}
 	}
-	return dns.Ensure(svc)
+	drifts, err := dns.DetectDrift(r.Route53, svc)
+	if err != nil {
+		return 0, err
+	}
+	annotations := map[string]string{}
+	for k, v := range svc.Annotations {
+		annotations[k] = v
+	}
+	if err := dns.Ensure(r.Route53, svc); err != nil {
+		return 0, err
+	}
+	r.reportDrifts(svc, drifts)
+	// the changes are polled on requeues so that the worker isn't blocked until they propagate
+	synced, err := dns.CheckPropagation(r.Route53, svc)
+	if err != nil {
+		return 0, err
+	}
+	after := r.ResyncPeriod
+	if !synced {
+		after = dns.PropagationPollInterval
+	}
+	if reflect.DeepEqual(svc.Annotations, annotations) && !draining {
+		return after, nil
+	}
+	return after, r.Update(context.TODO(), svc, &client.UpdateOptions{})
+}
+
+// reportDrifts records the record sets Ensure restored.
+func (r *ServiceReconciler) reportDrifts(svc *corev1.Service, drifts []dns.Drift) {
+	if len(drifts) == 0 {
+		return
+	}
+	msgs := []string{}
+	for _, d := range drifts {
+		metrics.DriftCorrections.WithLabelValues(d.Type, d.Reason).Inc()
+		msgs = append(msgs, d.String())
+	}
+	r.Recorder.Eventf(svc, corev1.EventTypeWarning, "DriftCorrected",
+		"restored record sets changed outside of external-route53: %s", strings.Join(msgs, ", "))
 }
 
 func (r *ServiceReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&corev1.Service{}).
+		WithOptions(controller.Options{MaxConcurrentReconciles: r.MaxConcurrentReconciles}).
 		Complete(r)
 }
@@ -0,0 +1,91 @@
+package controllers
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	fakeclient "sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func newFakeClient(objs ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = route53v1.AddToScheme(scheme)
+	return fakeclient.NewFakeClientWithScheme(scheme, objs...)
+}
+
+func TestServiceReconcilerReleasesServicesWithoutRecords(t *testing.T) {
+	now := metav1.Now()
+	tests := []struct {
+		name string
+		svc  *corev1.Service
+	}{
+		{
+			// a load balancer deleted before it got its ingress
+			name: "deleted",
+			svc: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test",
+					Namespace:         "default",
+					UID:               "uid",
+					Finalizers:        []string{serviceFinalizer},
+					DeletionTimestamp: &now,
+					Annotations: map[string]string{
+						dns.HostnameAnnotationKey: "test.test.takutakahashi.dev",
+					},
+				},
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			},
+		},
+		{
+			name: "hostname dropped",
+			svc: &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "test",
+					Namespace:   "default",
+					UID:         "uid",
+					Finalizers:  []string{serviceFinalizer},
+					Annotations: map[string]string{},
+				},
+				Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := fake.New()
+			r := &ServiceReconciler{
+				Client:           newFakeClient(tt.svc),
+				Log:              ctrl.Log.WithName("test"),
+				Route53:          api,
+				DrainGracePeriod: time.Minute,
+			}
+			nn := types.NamespacedName{Namespace: "default", Name: "test"}
+			res, err := r.Reconcile(ctrl.Request{NamespacedName: nn})
+			if err != nil || res.RequeueAfter != 0 {
+				t.Fatalf("Reconcile() = %v, %v, want the service released", res, err)
+			}
+			svc := corev1.Service{}
+			if err := r.Get(context.TODO(), nn, &svc); err != nil {
+				t.Fatal(err)
+			}
+			if containsString(svc.Finalizers, serviceFinalizer) {
+				t.Errorf("Reconcile() kept the finalizer")
+			}
+			if _, ok := svc.Annotations[dns.DrainUntilAnnotationKey]; ok {
+				t.Errorf("Reconcile() drained the service")
+			}
+		})
+	}
+}
@@ -0,0 +1,175 @@
+/*
+
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/healthcheck"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	"github.com/takutakahashi/external-route53/pkg/trafficshift"
+)
+
+// TrafficShiftReconciler reconciles a TrafficShift object
+type TrafficShiftReconciler struct {
+	client.Client
+	Log     logr.Logger
+	Scheme  *runtime.Scheme
+	Route53 r53api.API
+}
+
+// +kubebuilder:rbac:groups=route53.takutakahashi.dev,resources=trafficshifts,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=route53.takutakahashi.dev,resources=trafficshifts/status,verbs=get;update;patch
+
+func (r *TrafficShiftReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("trafficshift", req.NamespacedName)
+	ts := route53v1.TrafficShift{}
+	if err := r.Get(ctx, req.NamespacedName, &ts); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		} else {
+			return ctrl.Result{}, err
+		}
+	}
+	if ts.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	after, err := r.reconcile(ts.DeepCopy())
+	if err != nil {
+		log.Error(err, "failed to shift traffic")
+		return ctrl.Result{RequeueAfter: time.Minute}, nil
+	}
+	return ctrl.Result{RequeueAfter: after}, nil
+}
+
+// reconcile sets the weights of the current step to the services.
+// The service controller applies them to the weighted records.
+func (r *TrafficShiftReconciler) reconcile(ts *route53v1.TrafficShift) (time.Duration, error) {
+	if err := trafficshift.Validate(ts); err != nil {
+		return 0, r.setInvalid(ts, err.Error())
+	}
+	svcs := []*corev1.Service{}
+	current := map[string]int{}
+	healthy := true
+	for _, s := range ts.Spec.Services {
+		svc := corev1.Service{}
+		nn := types.NamespacedName{Name: s.Name, Namespace: ts.Namespace}
+		if err := r.Get(context.TODO(), nn, &svc); err != nil {
+			if errors.IsNotFound(err) {
+				return time.Minute, r.setInvalid(ts, fmt.Sprintf("service %s is not found", s.Name))
+			}
+			return 0, err
+		}
+		if len(svcs) > 0 && svc.Annotations[dns.HostnameAnnotationKey] != svcs[0].Annotations[dns.HostnameAnnotationKey] {
+			return 0, r.setInvalid(ts, "services must share the hostname")
+		}
+		if key := dns.RoutingAnnotation(&svc); key != "" {
+			return 0, r.setInvalid(ts, fmt.Sprintf("service %s has %s, only weighted records can be shifted", s.Name, key))
+		}
+		// the records are weighted by 1 without the weight annotation
+		current[s.Name] = 1
+		if w, ok := svc.Annotations[dns.WeightAnnotationKey]; ok {
+			ret, err := strconv.Atoi(w)
+			if err != nil {
+				return 0, err
+			}
+			current[s.Name] = ret
+		}
+		if id := svc.Annotations[dns.HealthCheckIdAnnotationKey]; id != "" {
+			ok, err := healthcheck.Healthy(r.Route53, id)
+			if err != nil {
+				return 0, err
+			}
+			healthy = healthy && ok
+		}
+		svcs = append(svcs, svc.DeepCopy())
+	}
+	next := ts.DeepCopy()
+	now := time.Now()
+	after := trafficshift.Next(next, current, healthy, now)
+	trafficshift.SetCondition(next, route53v1.TrafficShiftConditionValid, "True", "", now)
+	for i, svc := range svcs {
+		w := strconv.Itoa(next.Status.Weights[i].Weight)
+		if svc.Annotations[dns.WeightAnnotationKey] == w {
+			continue
+		}
+		svc.Annotations[dns.WeightAnnotationKey] = w
+		if err := r.Update(context.TODO(), svc, &client.UpdateOptions{}); err != nil {
+			return 0, err
+		}
+	}
+	if reflect.DeepEqual(next.Status, ts.Status) {
+		return after, nil
+	}
+	return after, r.Status().Update(context.TODO(), next)
+}
+
+// setInvalid reports why the traffic shift can't proceed in the message and the Valid condition.
+func (r *TrafficShiftReconciler) setInvalid(ts *route53v1.TrafficShift, message string) error {
+	next := ts.DeepCopy()
+	next.Status.Message = message
+	trafficshift.SetCondition(next, route53v1.TrafficShiftConditionValid, "False", message, time.Now())
+	if reflect.DeepEqual(next.Status, ts.Status) {
+		return nil
+	}
+	return r.Status().Update(context.TODO(), next)
+}
+
+// trafficShiftsOf returns the traffic shifts of the service, so that a change to the service is shifted without waiting for a requeue.
+func (r *TrafficShiftReconciler) trafficShiftsOf(o handler.MapObject) []ctrl.Request {
+	list := route53v1.TrafficShiftList{}
+	if err := r.List(context.TODO(), &list, client.InNamespace(o.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list traffic shifts")
+		return nil
+	}
+	ret := []ctrl.Request{}
+	for _, ts := range list.Items {
+		for _, s := range ts.Spec.Services {
+			if s.Name == o.Meta.GetName() {
+				ret = append(ret, ctrl.Request{NamespacedName: types.NamespacedName{Name: ts.Name, Namespace: ts.Namespace}})
+				break
+			}
+		}
+	}
+	return ret
+}
+
+func (r *TrafficShiftReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&route53v1.TrafficShift{}).
+		Watches(&source.Kind{Type: &corev1.Service{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.trafficShiftsOf),
+		}).
+		Complete(r)
+}
@@ -0,0 +1,173 @@
+package controllers
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	"github.com/takutakahashi/external-route53/pkg/condition"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/healthcheck"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+)
+
+func newTrafficShiftObjects(onUnhealthy route53v1.TrafficShiftUnhealthyPolicy, healthCheckID string) (*route53v1.TrafficShift, *corev1.Service, *corev1.Service) {
+	ts := &route53v1.TrafficShift{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "default", Generation: 1},
+		Spec: route53v1.TrafficShiftSpec{
+			Services: []route53v1.TrafficShiftWeight{
+				{Name: "stable", Weight: 0},
+				{Name: "canary", Weight: 100},
+			},
+			StepPercent: 40,
+			Interval:    metav1.Duration{Duration: 5 * time.Minute},
+			OnUnhealthy: onUnhealthy,
+		},
+	}
+	service := func(name, weight string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Annotations: map[string]string{
+					dns.HostnameAnnotationKey:      "app.test.takutakahashi.dev",
+					dns.WeightAnnotationKey:        weight,
+					dns.HealthCheckIdAnnotationKey: healthCheckID,
+				},
+			},
+		}
+	}
+	return ts, service("stable", "100"), service("canary", "0")
+}
+
+func TestTrafficShiftReconciler(t *testing.T) {
+	tests := []struct {
+		name        string
+		onUnhealthy route53v1.TrafficShiftUnhealthyPolicy
+		// health of the health check at each reconcile
+		healthy     []bool
+		wantWeights []string
+		wantPhase   route53v1.TrafficShiftPhase
+	}{
+		{
+			name:        "step",
+			healthy:     []bool{true},
+			wantWeights: []string{"60", "40"},
+			wantPhase:   route53v1.PhaseProgressing,
+		},
+		{
+			name:        "pause",
+			healthy:     []bool{true, false},
+			wantWeights: []string{"60", "40"},
+			wantPhase:   route53v1.PhasePaused,
+		},
+		{
+			name:        "rollback",
+			onUnhealthy: route53v1.UnhealthyPolicyRollback,
+			healthy:     []bool{true, false},
+			wantWeights: []string{"100", "0"},
+			wantPhase:   route53v1.PhaseRolledBack,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := fake.New()
+			h, err := healthcheck.Ensure(api, &route53v1.HealthCheck{
+				ObjectMeta: metav1.ObjectMeta{Name: "stable", Namespace: "default"},
+				Spec: route53v1.HealthCheckSpec{
+					Enabled:  true,
+					Protocol: route53v1.ProtocolTCP,
+					Port:     443,
+					Endpoint: route53v1.HealthCheckEndpoint{Address: "10.10.10.1"},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ts, stable, canary := newTrafficShiftObjects(tt.onUnhealthy, h.Status.ID)
+			r := &TrafficShiftReconciler{
+				Client:  newFakeClient(ts, stable, canary),
+				Log:     ctrl.Log.WithName("test"),
+				Route53: api,
+			}
+			nn := types.NamespacedName{Name: "test", Namespace: "default"}
+			for _, healthy := range tt.healthy {
+				api.SetHealthCheckStatus(h.Status.ID, healthy)
+				if _, err := r.Reconcile(ctrl.Request{NamespacedName: nn}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := []string{}
+			for _, name := range []string{"stable", "canary"} {
+				svc := corev1.Service{}
+				if err := r.Get(context.TODO(), types.NamespacedName{Name: name, Namespace: "default"}, &svc); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, svc.Annotations[dns.WeightAnnotationKey])
+			}
+			if !reflect.DeepEqual(got, tt.wantWeights) {
+				t.Errorf("Reconcile() weights = %v, want %v", got, tt.wantWeights)
+			}
+			if err := r.Get(context.TODO(), nn, ts); err != nil {
+				t.Fatal(err)
+			}
+			if ts.Status.Phase != tt.wantPhase {
+				t.Errorf("Reconcile() phase = %v, want %v", ts.Status.Phase, tt.wantPhase)
+			}
+			if c, _, err := condition.GetTypedCondition(ts.Status.Conditions, route53v1.TrafficShiftConditionValid); err != nil || c.Status != "True" {
+				t.Errorf("Reconcile() condition = %+v, %v, want Valid", c, err)
+			}
+		})
+	}
+}
+
+func TestTrafficShiftReconcilerRejectsOtherRoutingPolicies(t *testing.T) {
+	ts, stable, canary := newTrafficShiftObjects("", "")
+	canary.Annotations["external-dns.alpha.kubernetes.io/aws-failover"] = "SECONDARY"
+	r := &TrafficShiftReconciler{
+		Client:  newFakeClient(ts, stable, canary),
+		Log:     ctrl.Log.WithName("test"),
+		Route53: fake.New(),
+	}
+	nn := types.NamespacedName{Name: "test", Namespace: "default"}
+	if _, err := r.Reconcile(ctrl.Request{NamespacedName: nn}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Get(context.TODO(), nn, ts); err != nil {
+		t.Fatal(err)
+	}
+	if c, _, err := condition.GetTypedCondition(ts.Status.Conditions, route53v1.TrafficShiftConditionValid); err != nil || c.Status != "False" {
+		t.Errorf("Reconcile() condition = %+v, %v, want not Valid", c, err)
+	}
+	svc := corev1.Service{}
+	if err := r.Get(context.TODO(), types.NamespacedName{Name: "stable", Namespace: "default"}, &svc); err != nil {
+		t.Fatal(err)
+	}
+	if w := svc.Annotations[dns.WeightAnnotationKey]; w != "100" {
+		t.Errorf("Reconcile() changed the weight of stable to %s", w)
+	}
+}
+
+func TestTrafficShiftReconcilerMapsServices(t *testing.T) {
+	ts, stable, canary := newTrafficShiftObjects("", "")
+	other := stable.DeepCopy()
+	other.Name = "other"
+	r := &TrafficShiftReconciler{
+		Client: newFakeClient(ts, stable, canary, other),
+		Log:    ctrl.Log.WithName("test"),
+	}
+	want := []ctrl.Request{{NamespacedName: types.NamespacedName{Name: "test", Namespace: "default"}}}
+	if got := r.trafficShiftsOf(handler.MapObject{Meta: canary, Object: canary}); !reflect.DeepEqual(got, want) {
+		t.Errorf("trafficShiftsOf() = %v, want %v", got, want)
+	}
+	if got := r.trafficShiftsOf(handler.MapObject{Meta: other, Object: other}); len(got) != 0 {
+		t.Errorf("trafficShiftsOf() = %v for a service of no traffic shift", got)
+	}
+}
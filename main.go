@@ -18,7 +18,9 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -30,6 +32,8 @@ import (
 
 	route53v1 "github.com/takutakahashi/external-route53/api/v1"
 	"github.com/takutakahashi/external-route53/controllers"
+	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -53,9 +57,48 @@ func main() {
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	var drainGracePeriod time.Duration
+	flag.DurationVar(&drainGracePeriod, "drain-grace-period", 30*time.Second,
+		"How long the records of a deleted service are drained after their TTL before they are deleted.")
+	var resyncPeriod time.Duration
+	flag.DurationVar(&resyncPeriod, "resync-period", 10*time.Minute,
+		"How often the records of a service are compared with Route53 to restore the ones changed outside of the controller.")
+	flag.DurationVar(&dns.RecordCacheRefreshInterval, "record-cache-refresh-interval", time.Minute,
+		"How often the cached record sets of a hosted zone are listed from Route53 again.")
+	flag.DurationVar(&dns.ChangeBatchWindow, "change-batch-window", 100*time.Millisecond,
+		"How long the changes to a hosted zone are collected before they are submitted in one change batch.")
+	flag.DurationVar(&dns.PropagationPollInterval, "propagation-poll-interval", 10*time.Second,
+		"How often the pending changes to the records of a service are polled until they are INSYNC.")
+	flag.StringVar(&dns.PreferredZoneType, "preferred-zone-type", "public",
+		"The type of the hosted zone chosen when a public and a private hosted zone have the same name, public or private.")
+	flag.StringVar(&dns.DomainFilter, "domain-filter", "",
+		"The domains the hostnames must be in, separated by commas. Every hostname is allowed when it's empty.")
+	var maxConcurrentReconciles int
+	flag.IntVar(&maxConcurrentReconciles, "max-concurrent-reconciles", 4,
+		"How many services are reconciled at once.")
+	var gcInterval, gcMinAge time.Duration
+	var gcDryRun bool
+	flag.DurationVar(&gcInterval, "gc-interval", 0,
+		"How often the hosted zones of HOSTED_ZONE_ID or --domain-filter are scanned for the records of services which no longer exist. 0 disables the garbage collection.")
+	flag.DurationVar(&gcMinAge, "gc-min-age", time.Hour,
+		"How long the records of a service which no longer exists are kept before they are deleted.")
+	flag.BoolVar(&gcDryRun, "gc-dry-run", true,
+		"Log the records of services which no longer exist instead of deleting them. Set it to false to delete them.")
+	flag.BoolVar(&dns.CollectLegacyRecords, "gc-legacy-records", false,
+		"Delete the records with TXT records not recording the cluster ID as well. Set it only when no other cluster manages the hosted zones.")
+	flag.StringVar(&dns.OwnerID, "cluster-id", "default",
+		"The owner ID recorded in the TXT records, clusters sharing a hosted zone must have different IDs.")
+	flag.StringVar(&dns.AdoptExternalDNSOwnerID, "adopt-external-dns-owner-id", "",
+		"Take over the records of external-dns with this owner ID and rewrite their TXT records. Empty disables the adoption.")
+	flag.StringVar(&dns.ExternalDNSTxtPrefix, "external-dns-txt-prefix", "",
+		"The --txt-prefix of the external-dns whose records are taken over.")
 	flag.Parse()
 
 	ctrl.SetLogger(zap.New(zap.UseDevMode(true)))
+	if dns.PreferredZoneType != "public" && dns.PreferredZoneType != "private" {
+		setupLog.Error(fmt.Errorf("unknown zone type %s", dns.PreferredZoneType), "invalid --preferred-zone-type")
+		os.Exit(1)
+	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
@@ -69,22 +112,51 @@ func main() {
 		os.Exit(1)
 	}
 
+	route53API := r53api.New()
 	if err = (&controllers.HealthCheckReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("HealthCheck"),
-		Scheme: mgr.GetScheme(),
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("controllers").WithName("HealthCheck"),
+		Scheme:  mgr.GetScheme(),
+		Route53: route53API,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "HealthCheck")
 		os.Exit(1)
 	}
 	if err = (&controllers.ServiceReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("Service"),
-		Scheme: mgr.GetScheme(),
+		Client:                  mgr.GetClient(),
+		Log:                     ctrl.Log.WithName("controllers").WithName("Service"),
+		Scheme:                  mgr.GetScheme(),
+		Route53:                 route53API,
+		Recorder:                mgr.GetEventRecorderFor("external-route53"),
+		DrainGracePeriod:        drainGracePeriod,
+		ResyncPeriod:            resyncPeriod,
+		MaxConcurrentReconciles: maxConcurrentReconciles,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Service")
 		os.Exit(1)
 	}
+	if err = (&controllers.TrafficShiftReconciler{
+		Client:  mgr.GetClient(),
+		Log:     ctrl.Log.WithName("controllers").WithName("TrafficShift"),
+		Scheme:  mgr.GetScheme(),
+		Route53: route53API,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "TrafficShift")
+		os.Exit(1)
+	}
+	if gcInterval > 0 {
+		if err = (&controllers.GarbageCollector{
+			Client:   mgr.GetClient(),
+			Log:      ctrl.Log.WithName("controllers").WithName("GarbageCollector"),
+			Route53:  route53API,
+			Interval: gcInterval,
+			MinAge:   gcMinAge,
+			DryRun:   gcDryRun,
+		}).SetupWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create garbage collector")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -0,0 +1,77 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+)
+
+// AdoptExternalDNSOwnerID is the owner ID of the external-dns whose records are taken over. empty disables the adoption.
+var AdoptExternalDNSOwnerID = ""
+
+// ExternalDNSTxtPrefix is the prefix of the TXT records of external-dns, its --txt-prefix.
+var ExternalDNSTxtPrefix = ""
+
+// externalDNSRecordSets returns the record sets to delete when the records of ro are taken over from external-dns:
+// the TXT records of external-dns, and the record without set identifier replaced by the record of ro.
+// Nothing is returned unless the record is owned by external-dns of AdoptExternalDNSOwnerID.
+func externalDNSRecordSets(api r53api.API, ro UpsertRecordSetOpt) ([]*route53.ResourceRecordSet, error) {
+	if AdoptExternalDNSOwnerID == "" {
+		return nil, nil
+	}
+	for _, identifier := range []string{ro.Identifier, ""} {
+		rs, err := lookupRecordSet(api, ro.HostedZoneID, ro.Hostname, ro.Type, identifier)
+		if err != nil {
+			return nil, err
+		}
+		if rs == nil {
+			continue
+		}
+		ret := []*route53.ResourceRecordSet{}
+		for _, name := range externalDNSTxtNames(ro) {
+			txt, err := lookupRecordSet(api, ro.HostedZoneID, name, "TXT", identifier)
+			if err != nil {
+				return nil, err
+			}
+			if txt != nil && ownedByExternalDNS(txt, ro) {
+				ret = append(ret, txt)
+			}
+		}
+		if len(ret) == 0 {
+			return nil, nil
+		}
+		if identifier != ro.Identifier {
+			ret = append([]*route53.ResourceRecordSet{rs}, ret...)
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+// externalDNSTxtNames returns the names of the TXT records external-dns may have set for the record,
+// the plain prefixed name and the name with the record type.
+func externalDNSTxtNames(ro UpsertRecordSetOpt) []string {
+	return []string{
+		ExternalDNSTxtPrefix + ro.Hostname,
+		fmt.Sprintf("%s%s-%s", ExternalDNSTxtPrefix, strings.ToLower(ro.Type), ro.Hostname),
+	}
+}
+
+// ownedByExternalDNS reports whether the TXT record is set by external-dns of AdoptExternalDNSOwnerID for the resource of ro.
+func ownedByExternalDNS(txt *route53.ResourceRecordSet, ro UpsertRecordSetOpt) bool {
+	for _, rr := range txt.ResourceRecords {
+		labels := parseLabels(aws.StringValue(rr.Value))
+		if labels["heritage"] != "external-dns" || labels["external-dns/owner"] != AdoptExternalDNSOwnerID {
+			continue
+		}
+		resource, ok := labels["external-dns/resource"]
+		if !ok || ro.Owner.Name == "" {
+			return true
+		}
+		return strings.EqualFold(resource, fmt.Sprintf("%s/%s/%s", ro.Owner.Kind, ro.Owner.Namespace, ro.Owner.Name))
+	}
+	return false
+}
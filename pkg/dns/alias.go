@@ -0,0 +1,141 @@
+package dns
+
+import (
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// canonicalHostedZones maps the DNS name suffix of AWS endpoints
+// to the hosted zone ID that alias records pointing at them must use.
+var canonicalHostedZones = map[string]string{
+	// Elastic Load Balancing: Application and Classic Load Balancers
+	"us-east-2.elb.amazonaws.com":         "Z3AADJGX6KTTL2",
+	"us-east-1.elb.amazonaws.com":         "Z35SXDOTRQ7X7K",
+	"us-west-1.elb.amazonaws.com":         "Z368ELLRRE2KJ0",
+	"us-west-2.elb.amazonaws.com":         "Z1H1FL5HABSF5",
+	"ca-central-1.elb.amazonaws.com":      "ZQSVJUPU6J1EY",
+	"ap-east-1.elb.amazonaws.com":         "Z3DQVH9N71FHZ0",
+	"ap-south-1.elb.amazonaws.com":        "ZP97RAFLXTNZK",
+	"ap-northeast-1.elb.amazonaws.com":    "Z14GRHDCWA56QT",
+	"ap-northeast-2.elb.amazonaws.com":    "ZWKZPGTI48KDX",
+	"ap-northeast-3.elb.amazonaws.com":    "Z5LXEXXYW11ES",
+	"ap-southeast-1.elb.amazonaws.com":    "Z1LMS91P8CMLE5",
+	"ap-southeast-2.elb.amazonaws.com":    "Z1GM3OXH4ZPM65",
+	"eu-central-1.elb.amazonaws.com":      "Z215JYRZR1TBD5",
+	"eu-west-1.elb.amazonaws.com":         "Z32O12XQLNTSW2",
+	"eu-west-2.elb.amazonaws.com":         "ZHURV8PSTC4K8",
+	"eu-west-3.elb.amazonaws.com":         "Z3Q77PNBQS71R4",
+	"eu-north-1.elb.amazonaws.com":        "Z23TAZ7KKW8LYX",
+	"eu-south-1.elb.amazonaws.com":        "Z3ULH7SSC9OV64",
+	"sa-east-1.elb.amazonaws.com":         "Z2P70J7HTTTPLU",
+	"me-south-1.elb.amazonaws.com":        "ZS929ML54UICD",
+	"af-south-1.elb.amazonaws.com":        "Z268VQBMOI5EKX",
+	"us-gov-west-1.elb.amazonaws.com":     "Z33AYJ8TM3BH4J",
+	"us-gov-east-1.elb.amazonaws.com":     "Z166TLBEWOO7G0",
+	"cn-north-1.elb.amazonaws.com.cn":     "Z1GDH35T77C1KE",
+	"cn-northwest-1.elb.amazonaws.com.cn": "ZM7IZAIOVVDZF",
+	// Network Load Balancers
+	"elb.us-east-2.amazonaws.com":         "ZLMOA37VPKANP",
+	"elb.us-east-1.amazonaws.com":         "Z26RNL4JYFTOTI",
+	"elb.us-west-1.amazonaws.com":         "Z24FKFUX50B4VW",
+	"elb.us-west-2.amazonaws.com":         "Z18D5FSROUN65G",
+	"elb.ca-central-1.amazonaws.com":      "Z2EPGBW3API2WT",
+	"elb.ap-east-1.amazonaws.com":         "Z12Y7K3UBGUAD1",
+	"elb.ap-south-1.amazonaws.com":        "ZVDDRBQ08TROA",
+	"elb.ap-northeast-1.amazonaws.com":    "Z31USIVHYNEOWT",
+	"elb.ap-northeast-2.amazonaws.com":    "ZIBE1TIR4HY56",
+	"elb.ap-northeast-3.amazonaws.com":    "Z1GWIQ4HH19I5X",
+	"elb.ap-southeast-1.amazonaws.com":    "ZKVM4W9LS7TM",
+	"elb.ap-southeast-2.amazonaws.com":    "ZCT6FZBF4DROD",
+	"elb.eu-central-1.amazonaws.com":      "Z3F0SRJ5LGBH90",
+	"elb.eu-west-1.amazonaws.com":         "Z2IFOLAFXWLO4F",
+	"elb.eu-west-2.amazonaws.com":         "ZD4D7Y8KGAS4G",
+	"elb.eu-west-3.amazonaws.com":         "Z1CMS0P5QUZ6D5",
+	"elb.eu-north-1.amazonaws.com":        "Z1UDT6IFJ4EJM",
+	"elb.eu-south-1.amazonaws.com":        "Z23146JA1KNAFP",
+	"elb.sa-east-1.amazonaws.com":         "ZTK26PT1VY4CU",
+	"elb.me-south-1.amazonaws.com":        "Z3QSRYVP46NYYV",
+	"elb.af-south-1.amazonaws.com":        "Z203XCE67M25HM",
+	"elb.us-gov-west-1.amazonaws.com":     "ZMG1MZ2THAWF1",
+	"elb.us-gov-east-1.amazonaws.com":     "Z1ZSMQQ6Q24QQ8",
+	"elb.cn-north-1.amazonaws.com.cn":     "Z3QFB96KMJ7ED6",
+	"elb.cn-northwest-1.amazonaws.com.cn": "ZQEIKTCZ8352D",
+	// CloudFront distributions, including edge-optimized API Gateway endpoints
+	"cloudfront.net": "Z2FDTNDATAQYW2",
+	// Global Accelerator
+	"awsglobalaccelerator.com": "Z2BJ6XQ5FK7U4H",
+	// S3 website endpoints
+	"s3-website-us-east-1.amazonaws.com":      "Z3AQBSTGFYJSTF",
+	"s3-website.us-east-2.amazonaws.com":      "Z2O1EMRO9K5GLX",
+	"s3-website-us-west-1.amazonaws.com":      "Z2F56UZL2M1ACD",
+	"s3-website-us-west-2.amazonaws.com":      "Z3BJ6K6RIION7M",
+	"s3-website.ca-central-1.amazonaws.com":   "Z1QDHH18159H29",
+	"s3-website.ap-south-1.amazonaws.com":     "Z11RGJOFQNVJUP",
+	"s3-website-ap-northeast-1.amazonaws.com": "Z2M4EHUR26P7ZW",
+	"s3-website.ap-northeast-2.amazonaws.com": "Z3W03O7B5YMIYP",
+	"s3-website-ap-southeast-1.amazonaws.com": "Z3O0J2DXBE1FTB",
+	"s3-website-ap-southeast-2.amazonaws.com": "Z1WCIGYICN2BYD",
+	"s3-website.eu-central-1.amazonaws.com":   "Z21DNDUVLTQW6Q",
+	"s3-website-eu-west-1.amazonaws.com":      "Z1BKCTXD74EZPE",
+	"s3-website.eu-west-2.amazonaws.com":      "Z3GKZC51ZF0DB4",
+	"s3-website.eu-west-3.amazonaws.com":      "Z3R1K369G5AVDG",
+	"s3-website.eu-north-1.amazonaws.com":     "Z3BAZG2TWCNX0D",
+	"s3-website-sa-east-1.amazonaws.com":      "Z7KQH4QJS55SO",
+	// regional API Gateway endpoints
+	"execute-api.us-east-1.amazonaws.com":      "Z1UJRXOUMOOFQ8",
+	"execute-api.us-east-2.amazonaws.com":      "ZOJJZC49E0EPZ",
+	"execute-api.us-west-1.amazonaws.com":      "Z2MUQ32089INYE",
+	"execute-api.us-west-2.amazonaws.com":      "Z2OJLYMUO9EFXC",
+	"execute-api.ca-central-1.amazonaws.com":   "Z19DQILCV0OWEC",
+	"execute-api.ap-south-1.amazonaws.com":     "Z3VO1THU9YC4UR",
+	"execute-api.ap-northeast-1.amazonaws.com": "Z1YSHQZHG15GKL",
+	"execute-api.ap-northeast-2.amazonaws.com": "Z20JF4UZKIW1U8",
+	"execute-api.ap-southeast-1.amazonaws.com": "ZL327KTPIQFUL",
+	"execute-api.ap-southeast-2.amazonaws.com": "Z2RPCDW04V8134",
+	"execute-api.eu-central-1.amazonaws.com":   "Z1U9ULNL0V5AJ3",
+	"execute-api.eu-west-1.amazonaws.com":      "ZLY8HYME6SFDD",
+	"execute-api.eu-west-2.amazonaws.com":      "ZJ5UAJN8Y3Z2Q",
+	"execute-api.eu-west-3.amazonaws.com":      "Z3KY65QIEKYHQQ",
+	"execute-api.eu-north-1.amazonaws.com":     "Z3UWIKFBOOGXPP",
+	"execute-api.sa-east-1.amazonaws.com":      "ZCMLWB8V5SYIT",
+}
+
+// canonicalHostedZoneID returns the hosted zone ID of the AWS endpoint serving hostname.
+// The longest matching suffix wins.
+func canonicalHostedZoneID(hostname string) (string, bool) {
+	hostname = strings.TrimSuffix(strings.ToLower(hostname), ".")
+	ret, matched := "", ""
+	for suffix, zoneID := range canonicalHostedZones {
+		if strings.HasSuffix(hostname, "."+suffix) && len(suffix) > len(matched) {
+			ret, matched = zoneID, suffix
+		}
+	}
+	return ret, matched != ""
+}
+
+// aliasHostedZoneID resolves the hosted zone ID of an alias target.
+// Targets which aren't AWS endpoints are records in the record's own zone.
+func aliasHostedZoneID(target, hostedZoneID string) string {
+	if zoneID, ok := canonicalHostedZoneID(target); ok {
+		return zoneID
+	}
+	return hostedZoneID
+}
+
+// loadBalancerHostname returns the first hostname ingress of a load balancer which has no IP ingress, ex: ELB.
+func loadBalancerHostname(svc *corev1.Service) string {
+	if svc.Spec.Type != corev1.ServiceTypeLoadBalancer {
+		return ""
+	}
+	hostname := ""
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" {
+			return ""
+		}
+		if hostname == "" {
+			hostname = ing.Hostname
+		}
+	}
+	return hostname
+}
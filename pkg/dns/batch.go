@@ -0,0 +1,200 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/sirupsen/logrus"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+)
+
+// ChangeBatchWindow is how long the changes to a hosted zone are collected before they are submitted in one change batch.
+var ChangeBatchWindow = 100 * time.Millisecond
+
+// The limits of a change batch of Route53. UPSERTs count twice.
+const (
+	maxBatchRecords = 1000
+	maxBatchChars   = 32000
+)
+
+// changeRequest is the changes of a caller, applied or rejected together.
+type changeRequest struct {
+	changes []*route53.Change
+	done    chan changeResult
+}
+
+// changeResult is the ID of the change containing the changes of a request, or the error rejecting them.
+type changeResult struct {
+	changeID string
+	err      error
+}
+
+type batchKey struct {
+	api          r53api.API
+	hostedZoneID string
+}
+
+var (
+	pendingChangesMu sync.Mutex
+	pendingChanges   = map[batchKey][]*changeRequest{}
+)
+
+// submitChanges submits the changes together with the changes to the hosted zone by other callers within ChangeBatchWindow,
+// and returns the ID of the change to track its propagation.
+func submitChanges(api r53api.API, hostedZoneID string, changes []*route53.Change) (string, error) {
+	req := &changeRequest{changes: changes, done: make(chan changeResult, 1)}
+	key := batchKey{api: api, hostedZoneID: strings.TrimPrefix(hostedZoneID, "/hostedzone/")}
+	pendingChangesMu.Lock()
+	if len(pendingChanges[key]) == 0 {
+		time.AfterFunc(ChangeBatchWindow, func() { flushChanges(key) })
+	}
+	pendingChanges[key] = append(pendingChanges[key], req)
+	pendingChangesMu.Unlock()
+	ret := <-req.done
+	return ret.changeID, ret.err
+}
+
+// zoneChanges collects the changes of a caller by hosted zone, they are submitted in one change batch per hosted zone.
+type zoneChanges struct {
+	zones   []string
+	changes map[string][]*route53.Change
+}
+
+func newZoneChanges() *zoneChanges {
+	return &zoneChanges{changes: map[string][]*route53.Change{}}
+}
+
+func (z *zoneChanges) add(hostedZoneID string, changes []*route53.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	if _, ok := z.changes[hostedZoneID]; !ok {
+		z.zones = append(z.zones, hostedZoneID)
+	}
+	z.changes[hostedZoneID] = append(z.changes[hostedZoneID], changes...)
+}
+
+// submit submits the changes of each hosted zone and returns the IDs of the changes.
+func (z *zoneChanges) submit(api r53api.API) ([]string, error) {
+	changeIDs := []string{}
+	for _, zone := range z.zones {
+		logrus.Info(z.changes[zone])
+		id, err := submitChanges(api, zone, z.changes[zone])
+		if err != nil {
+			return changeIDs, err
+		}
+		changeIDs = appendChangeID(changeIDs, id)
+	}
+	return changeIDs, nil
+}
+
+// flushChanges submits the pending changes to the hosted zone.
+// Route53 rejects a whole change batch when one of its changes is invalid,
+// then the changes of each caller are submitted again on their own to report the caller whose changes are invalid.
+// The other errors, e.g. throttling, are returned to every caller as is, submitting the changes one by one would make them worse.
+func flushChanges(key batchKey) {
+	pendingChangesMu.Lock()
+	reqs := pendingChanges[key]
+	pendingChanges[key] = nil
+	pendingChangesMu.Unlock()
+	results := map[*changeRequest]changeResult{}
+	for _, batch := range splitChangeRequests(reqs) {
+		ret := changeResourceRecordSets(key.api, key.hostedZoneID, batch)
+		for _, req := range batch {
+			if invalidChangeBatch(ret.err) && len(batch) > 1 {
+				results[req] = changeResourceRecordSets(key.api, key.hostedZoneID, []*changeRequest{req})
+			} else {
+				results[req] = ret
+			}
+		}
+	}
+	// the callers read the record sets they changed right after the results.
+	// a rejected change means the cached record sets may be out of date, they are listed again
+	rejected := false
+	for req, ret := range results {
+		if ret.err != nil {
+			rejected = true
+			continue
+		}
+		applyChanges(key.api, key.hostedZoneID, req.changes)
+	}
+	if rejected {
+		invalidateRecordSets(key.api, key.hostedZoneID)
+	}
+	for req, ret := range results {
+		req.done <- ret
+	}
+}
+
+// invalidChangeBatch reports whether Route53 rejected the changes themselves.
+func invalidChangeBatch(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	return ok && (aerr.Code() == route53.ErrCodeInvalidChangeBatch || aerr.Code() == route53.ErrCodeInvalidInput)
+}
+
+func changeResourceRecordSets(api r53api.API, hostedZoneID string, reqs []*changeRequest) changeResult {
+	changes := []*route53.Change{}
+	for _, req := range reqs {
+		changes = append(changes, req.changes...)
+	}
+	out, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(hostedZoneID),
+		ChangeBatch: &route53.ChangeBatch{
+			Comment: aws.String("change from external-route53"),
+			Changes: changes,
+		},
+	})
+	if err != nil {
+		return changeResult{err: err}
+	}
+	return changeResult{changeID: aws.StringValue(out.ChangeInfo.Id)}
+}
+
+// splitChangeRequests splits the requests into change batches within the limits of Route53.
+// The changes of a request are never split, a request over the limits is submitted alone.
+func splitChangeRequests(reqs []*changeRequest) [][]*changeRequest {
+	ret := [][]*changeRequest{}
+	batch := []*changeRequest{}
+	records, chars := 0, 0
+	for _, req := range reqs {
+		r, c := req.size()
+		if len(batch) > 0 && (records+r > maxBatchRecords || chars+c > maxBatchChars) {
+			ret = append(ret, batch)
+			batch = []*changeRequest{}
+			records, chars = 0, 0
+		}
+		batch = append(batch, req)
+		records += r
+		chars += c
+	}
+	if len(batch) > 0 {
+		ret = append(ret, batch)
+	}
+	return ret
+}
+
+// size returns the number of the records and the characters of their values as Route53 counts them.
+func (req *changeRequest) size() (int, int) {
+	records, chars := 0, 0
+	for _, c := range req.changes {
+		n := 1
+		if aws.StringValue(c.Action) == route53.ChangeActionUpsert {
+			n = 2
+		}
+		rs := c.ResourceRecordSet
+		r := len(rs.ResourceRecords)
+		if r == 0 {
+			// alias records have no value
+			r = 1
+		}
+		records += r * n
+		for _, rr := range rs.ResourceRecords {
+			chars += len(aws.StringValue(rr.Value)) * n
+		}
+	}
+	return records, chars
+}
@@ -3,14 +3,17 @@ package dns
 import (
 	"errors"
 	"fmt"
+	"net"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/sirupsen/logrus"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
 	corev1 "k8s.io/api/core/v1"
 )
 
@@ -24,83 +27,307 @@ const (
 	aliasAnnotationKey = "external-dns.alpha.kubernetes.io/alias"
 	// external-dns defined annotation keys for route53
 	HealthCheckIdAnnotationKey = "external-dns.alpha.kubernetes.io/aws-health-check-id"
-	weightAnnotationKey        = "external-dns.alpha.kubernetes.io/aws-weight"
+	WeightAnnotationKey        = "external-dns.alpha.kubernetes.io/aws-weight"
 	setIdentifierAnnotationKey = "external-dns.alpha.kubernetes.io/set-identifier"
+	// PRIMARY or SECONDARY, switches the record to failover routing instead of weighted
+	failoverAnnotationKey = "external-dns.alpha.kubernetes.io/aws-failover"
+	// AWS region of the target, switches the record to latency routing instead of weighted
+	regionAnnotationKey = "external-dns.alpha.kubernetes.io/aws-region"
+	// geolocation of the clients, switches the record to geolocation routing instead of weighted. "*" for the default record
+	geoContinentAnnotationKey   = "external-dns.alpha.kubernetes.io/aws-geolocation-continent-code"
+	geoCountryAnnotationKey     = "external-dns.alpha.kubernetes.io/aws-geolocation-country-code"
+	geoSubdivisionAnnotationKey = "external-dns.alpha.kubernetes.io/aws-geolocation-subdivision-code"
+	// location of the target, switches the record to geoproximity routing instead of weighted. one of region, local zone group or coordinates
+	geoProximityRegionAnnotationKey         = "external-dns.alpha.kubernetes.io/aws-geoproximity-region"
+	geoProximityLocalZoneGroupAnnotationKey = "external-dns.alpha.kubernetes.io/aws-geoproximity-local-zone-group"
+	// latitude and longitude in degrees, ex: 35.68,139.76
+	geoProximityCoordinatesAnnotationKey = "external-dns.alpha.kubernetes.io/aws-geoproximity-coordinates"
+	// -99 to 99, a positive bias expands the region routed to the record. default: 0
+	geoProximityBiasAnnotationKey = "external-dns.alpha.kubernetes.io/aws-geoproximity-bias"
 	// external-route53 defined annotation keys
 	// specified record-type: ex: A, CNAME
 	recordTypeAnnotationKey = "external-route53.io/record-type"
+	// set if both A and AAAA records will be created
+	dualStackAnnotationKey = "external-route53.io/dual-stack"
+	// set if multivalue answer records will be created, one record set per target
+	multiValueAnswerAnnotationKey = "external-route53.io/multivalue-answer"
+	// health check id of each multivalue answer target, ex: 10.0.0.1=id1,10.0.0.2=id2
+	multiValueHealthCheckIDsAnnotationKey = "external-route53.io/multivalue-health-check-ids"
 	// set if health check will be created
 	HealthCheckAnnotationKey = "external-route53.io/health-check"
 	// specifiy zone id
 	zoneAnnotationKey = "external-route53.io/hosted-zone-id"
+	// specify zone id of the alias target, ex: when the target is a record in another zone
+	aliasZoneAnnotationKey = "external-route53.io/alias-hosted-zone-id"
+	// set if alias records evaluate the health of their target. default: true
+	evaluateTargetHealthAnnotationKey = "external-route53.io/evaluate-target-health"
+	// records applied by the controller, used to delete exactly what was created
+	LastAppliedAnnotationKey = "external-route53.io/last-applied"
 )
 
 type UpsertRecordSetOpt struct {
-	Hostname        string
-	Type            string
-	Identifier      string
-	HealthCheckID   string
-	HostedZoneID    string
-	Weight          int
-	TTL             int
-	Alias           bool
-	TargetHostname  string
-	TargetIPAddress string
-	TXTPrefix       string
+	Hostname           string
+	Type               string
+	Identifier         string
+	HealthCheckID      string
+	HostedZoneID       string
+	Weight             int
+	Failover           string
+	Region             string
+	GeoContinentCode   string
+	GeoCountryCode     string
+	GeoSubdivisionCode string
+	// GeoProximity* locate the target of geoproximity routing
+	GeoProximityRegion         string
+	GeoProximityLocalZoneGroup string
+	GeoProximityLatitude       string
+	GeoProximityLongitude      string
+	GeoProximityBias           int
+	MultiValueAnswer           bool
+	TTL                        int
+	Alias                      bool
+	AliasHostedZoneID          string
+	EvaluateTargetHealth       bool
+	TargetHostname             string
+	TargetIPAddresses          []string
+	TXTPrefix                  string
+	// Owner is recorded in the TXT record. Records without the owner keep the legacy TXT record value
+	Owner Owner
 }
 
 func SatisfiedAliasRecordCreation(svc *corev1.Service) error {
 	return nil
 }
 
-func Ensure(svc *corev1.Service) error {
-	ro, err := toUpsertRecordSetOpt(svc)
+// Ensure upserts the records of the service and deletes the previously applied ones it replaces.
+// The applied records are stored in the last-applied annotation of svc.
+func Ensure(api r53api.API, svc *corev1.Service) error {
+	prev, err := lastApplied(svc)
 	if err != nil {
 		return err
 	}
-	return ensureRecord(ro)
+	if err := restoreTxtRecords(api, prev); err != nil {
+		return err
+	}
+	ros, err := toUpsertRecordSetOpt(api, svc)
+	if err != nil {
+		return err
+	}
+	// the changes are submitted together, a service waits for one change batch per hosted zone
+	changes := newZoneChanges()
+	for _, p := range prev {
+		if containsRecordSet(ros, p) {
+			continue
+		}
+		cs, err := deleteChanges(api, p)
+		if err != nil {
+			return err
+		}
+		changes.add(p.HostedZoneID, cs)
+	}
+	for _, ro := range ros {
+		if err := validateRecordSetOpt(api, ro); err != nil {
+			return err
+		}
+		cs, err := upsertChanges(api, ro)
+		if err != nil {
+			return err
+		}
+		changes.add(ro.HostedZoneID, cs)
+	}
+	changeIDs, err := changes.submit(api)
+	if err != nil {
+		return err
+	}
+	if len(changeIDs) > 0 {
+		setChangesPending(svc, changeIDs, time.Now())
+	}
+	return setLastApplied(svc, ros)
 }
-func Delete(svc *corev1.Service) error {
-	ro, err := toUpsertRecordSetOpt(svc)
+
+// Delete deletes the records applied for the service.
+// Services without the last-applied annotation have no records applied, the records are never computed from the service.
+func Delete(api r53api.API, svc *corev1.Service) error {
+	ros, err := lastApplied(svc)
 	if err != nil {
 		return err
 	}
-	return delete(ro)
+	changes := newZoneChanges()
+	for _, ro := range ros {
+		cs, err := deleteChanges(api, ro)
+		if err != nil {
+			return err
+		}
+		changes.add(ro.HostedZoneID, cs)
+	}
+	// the records are deleted all at once or kept for the next try
+	if _, err := changes.submit(api); err != nil {
+		return err
+	}
+	clearLastApplied(svc)
+	return nil
 }
 
-func toUpsertRecordSetOpt(svc *corev1.Service) (UpsertRecordSetOpt, error) {
+// RoutingAnnotation returns the annotation of the service switching its records from weighted to another routing policy.
+// It's empty for weighted records.
+func RoutingAnnotation(svc *corev1.Service) string {
+	for _, key := range []string{failoverAnnotationKey, regionAnnotationKey, geoContinentAnnotationKey, geoCountryAnnotationKey, geoSubdivisionAnnotationKey,
+		geoProximityRegionAnnotationKey, geoProximityLocalZoneGroupAnnotationKey, geoProximityCoordinatesAnnotationKey} {
+		if _, ok := svc.Annotations[key]; ok {
+			return key
+		}
+	}
+	if ok, _ := strconv.ParseBool(svc.Annotations[multiValueAnswerAnnotationKey]); ok {
+		return multiValueAnswerAnnotationKey
+	}
+	return ""
+}
+
+func toUpsertRecordSetOpt(api r53api.API, svc *corev1.Service) ([]UpsertRecordSetOpt, error) {
 	var w, ttl int = 1, 10
-	_, ok := svc.Annotations[weightAnnotationKey]
+	_, ok := svc.Annotations[WeightAnnotationKey]
 	if ok {
-		ret, err := strconv.Atoi(svc.Annotations[weightAnnotationKey])
+		ret, err := strconv.Atoi(svc.Annotations[WeightAnnotationKey])
 		if err != nil {
-			return UpsertRecordSetOpt{}, err
+			return nil, err
 		}
 		w = ret
 	}
+	failover, ok := svc.Annotations[failoverAnnotationKey]
+	if ok {
+		failover = strings.ToUpper(failover)
+		if failover != route53.ResourceRecordSetFailoverPrimary && failover != route53.ResourceRecordSetFailoverSecondary {
+			return nil, fmt.Errorf("failover must be PRIMARY or SECONDARY: %s", svc.Annotations[failoverAnnotationKey])
+		}
+		if _, ok := svc.Annotations[WeightAnnotationKey]; ok {
+			return nil, errors.New("failover and weight can't be specified together")
+		}
+		w = 0
+	}
+	region, ok := svc.Annotations[regionAnnotationKey]
+	if ok {
+		if !supportedRegion(region) {
+			return nil, fmt.Errorf("region is not supported by latency routing: %s", region)
+		}
+		if _, ok := svc.Annotations[WeightAnnotationKey]; ok {
+			return nil, errors.New("region and weight can't be specified together")
+		}
+		if failover != "" {
+			return nil, errors.New("region and failover can't be specified together")
+		}
+		w = 0
+	}
+	continent := strings.ToUpper(svc.Annotations[geoContinentAnnotationKey])
+	country := strings.ToUpper(svc.Annotations[geoCountryAnnotationKey])
+	subdivision := strings.ToUpper(svc.Annotations[geoSubdivisionAnnotationKey])
+	if continent != "" || country != "" || subdivision != "" {
+		if err := validateGeoLocation(continent, country, subdivision); err != nil {
+			return nil, err
+		}
+		if _, ok := svc.Annotations[WeightAnnotationKey]; ok {
+			return nil, errors.New("geolocation and weight can't be specified together")
+		}
+		if failover != "" || region != "" {
+			return nil, errors.New("geolocation can't be specified with failover or region")
+		}
+		w = 0
+	}
+	var multiValueAnswer bool
+	if s, ok := svc.Annotations[multiValueAnswerAnnotationKey]; ok {
+		ret, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		multiValueAnswer = ret
+	}
+	if multiValueAnswer {
+		if _, ok := svc.Annotations[WeightAnnotationKey]; ok {
+			return nil, errors.New("multivalue answer and weight can't be specified together")
+		}
+		if failover != "" || region != "" || continent != "" || country != "" || subdivision != "" {
+			return nil, errors.New("multivalue answer can't be specified with failover, region or geolocation")
+		}
+		w = 0
+	}
+	geoProximity, err := parseGeoProximity(svc)
+	if err != nil {
+		return nil, err
+	}
+	if routingPolicy(geoProximity) == "geoproximity" {
+		if _, ok := svc.Annotations[WeightAnnotationKey]; ok {
+			return nil, errors.New("geoproximity and weight can't be specified together")
+		}
+		if failover != "" || region != "" || continent != "" || country != "" || subdivision != "" || multiValueAnswer {
+			return nil, errors.New("geoproximity can't be specified with failover, region, geolocation or multivalue answer")
+		}
+		w = 0
+	}
+	healthCheckIDs, err := parseHealthCheckIDs(svc.Annotations[multiValueHealthCheckIDsAnnotationKey])
+	if err != nil {
+		return nil, err
+	}
 	_, ok = svc.Annotations[ttlAnnotationKey]
 	if ok {
 		ret, err := strconv.Atoi(svc.Annotations[ttlAnnotationKey])
 		if err != nil {
-			return UpsertRecordSetOpt{}, err
+			return nil, err
 		}
 		ttl = ret
 	}
+	targetHostname := loadBalancerHostname(svc)
+	if svc.Spec.Type == corev1.ServiceTypeExternalName {
+		targetHostname = svc.Spec.ExternalName
+	}
+	_, canonical := canonicalHostedZoneID(targetHostname)
 	var alias bool
 	_, ok = svc.Annotations[aliasAnnotationKey]
 	if ok {
 		ret, err := strconv.ParseBool(svc.Annotations[aliasAnnotationKey])
 		if err != nil {
-			return UpsertRecordSetOpt{}, err
+			return nil, err
 		}
 		alias = ret
 	} else {
-		alias = svc.Spec.Type == corev1.ServiceTypeExternalName
+		alias = canonical
 	}
 	recordType, ok := svc.Annotations[recordTypeAnnotationKey]
 	if !ok {
 		recordType = "A"
 	}
+	recordTypes := []string{recordType}
+	if s, ok := svc.Annotations[dualStackAnnotationKey]; ok {
+		dualStack, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		if dualStack {
+			recordTypes = []string{"A", "AAAA"}
+		}
+	}
+	evaluateTargetHealth := true
+	if s, ok := svc.Annotations[evaluateTargetHealthAnnotationKey]; ok {
+		ret, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, err
+		}
+		evaluateTargetHealth = ret
+	}
+	if targetHostname != "" && !alias {
+		// a hostname target without alias is published as CNAME
+		recordTypes = []string{"CNAME"}
+	}
+	if targetHostname == "" && len(recordTypes) > 1 {
+		// dual-stack services publish the families they have addresses of, e.g. only A for an IPv4-only load balancer
+		types := []string{}
+		for _, t := range recordTypes {
+			if len(targetIPAddresses(svc, t)) > 0 {
+				types = append(types, t)
+			}
+		}
+		if len(types) > 0 {
+			recordTypes = types
+		}
+	}
 	identifier, ok := svc.Annotations[setIdentifierAnnotationKey]
 	if !ok {
 		identifier = fmt.Sprintf("%s/%s/%s", svc.Namespace, svc.Name, svc.UID)
@@ -109,143 +336,373 @@ func toUpsertRecordSetOpt(svc *corev1.Service) (UpsertRecordSetOpt, error) {
 	if s, ok := svc.Annotations[zoneAnnotationKey]; ok {
 		hostedZoneID = s
 	}
-	var thn, tip string = "", ""
-	switch svc.Spec.Type {
-	case corev1.ServiceTypeExternalName:
-		thn = svc.Spec.ExternalName
-	case corev1.ServiceTypeLoadBalancer:
-		tip = svc.Status.LoadBalancer.Ingress[0].IP
-	}
-	ro := UpsertRecordSetOpt{
-		Hostname:        svc.Annotations[HostnameAnnotationKey],
-		Type:            recordType,
-		Identifier:      identifier,
-		HealthCheckID:   svc.Annotations[HealthCheckIdAnnotationKey],
-		HostedZoneID:    hostedZoneID,
-		Weight:          w,
-		TTL:             ttl,
-		Alias:           alias,
-		TargetHostname:  thn,
-		TargetIPAddress: tip,
-		TXTPrefix:       "extr53-",
+	hostnames := hostnames(svc)
+	if len(hostnames) == 0 {
+		return nil, errors.New("hostname is not found")
 	}
-	if err := validateRecordSetOpt(ro); err != nil {
-		return UpsertRecordSetOpt{}, err
+	ros := []UpsertRecordSetOpt{}
+	for _, hostname := range hostnames {
+		if !domainAllowed(hostname) {
+			return nil, fmt.Errorf("hostname %s is not in the domain filter", hostname)
+		}
+		zoneID := hostedZoneID
+		if zoneID == "" {
+			// neither the env nor the annotation specify the zone, find it from the hostname
+			ret, err := findHostedZoneID(api, hostname)
+			if err != nil {
+				return nil, err
+			}
+			zoneID = ret
+		}
+		for _, t := range recordTypes {
+			var thn, azid string = "", ""
+			var tips []string = nil
+			if targetHostname != "" {
+				thn = targetHostname
+			} else {
+				tips = targetIPAddresses(svc, t)
+			}
+			if alias {
+				azid = aliasHostedZoneID(thn, zoneID)
+				if s, ok := svc.Annotations[aliasZoneAnnotationKey]; ok {
+					azid = s
+				}
+			}
+			ro := UpsertRecordSetOpt{
+				Hostname:                   hostname,
+				Type:                       t,
+				Identifier:                 identifier,
+				HealthCheckID:              svc.Annotations[HealthCheckIdAnnotationKey],
+				HostedZoneID:               zoneID,
+				Weight:                     w,
+				Failover:                   failover,
+				Region:                     region,
+				GeoContinentCode:           continent,
+				GeoCountryCode:             country,
+				GeoSubdivisionCode:         subdivision,
+				GeoProximityRegion:         geoProximity.GeoProximityRegion,
+				GeoProximityLocalZoneGroup: geoProximity.GeoProximityLocalZoneGroup,
+				GeoProximityLatitude:       geoProximity.GeoProximityLatitude,
+				GeoProximityLongitude:      geoProximity.GeoProximityLongitude,
+				GeoProximityBias:           geoProximity.GeoProximityBias,
+				MultiValueAnswer:           multiValueAnswer,
+				TTL:                        ttl,
+				Alias:                      alias,
+				AliasHostedZoneID:          azid,
+				EvaluateTargetHealth:       alias && evaluateTargetHealth,
+				TargetHostname:             thn,
+				TargetIPAddresses:          tips,
+				TXTPrefix:                  "extr53-",
+				Owner: Owner{
+					ClusterID: OwnerID,
+					Kind:      "Service",
+					Namespace: svc.Namespace,
+					Name:      svc.Name,
+					UID:       string(svc.UID),
+				},
+			}
+			if multiValueAnswer && !alias && len(tips) > 0 {
+				// each target is a record set of its own, so that Route53 drops the unhealthy ones from answers
+				for _, ip := range tips {
+					r := ro
+					r.Identifier = fmt.Sprintf("%s/%s", identifier, ip)
+					r.TargetIPAddresses = []string{ip}
+					if id, ok := healthCheckIDs[ip]; ok {
+						r.HealthCheckID = id
+					}
+					if err := validateRecordSetOpt(api, r); err != nil {
+						return nil, err
+					}
+					ros = append(ros, r)
+				}
+				continue
+			}
+			if err := validateRecordSetOpt(api, ro); err != nil {
+				return nil, err
+			}
+			ros = append(ros, ro)
+		}
 	}
-	return ro, nil
+	return ros, nil
 }
 
-func ensureRecord(ro UpsertRecordSetOpt) error {
-	if err := validateRecordSetOpt(ro); err != nil {
-		return err
+// parseHealthCheckIDs parses the health check ids of multivalue answer targets, ex: 10.0.0.1=id1,10.0.0.2=id2
+func parseHealthCheckIDs(s string) (map[string]string, error) {
+	ret := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		kv = strings.TrimSpace(kv)
+		if kv == "" {
+			continue
+		}
+		pair := strings.SplitN(kv, "=", 2)
+		ip := net.ParseIP(strings.TrimSpace(pair[0]))
+		if len(pair) != 2 || ip == nil || strings.TrimSpace(pair[1]) == "" {
+			return nil, fmt.Errorf("health check id must be specified as IP=ID: %s", kv)
+		}
+		ret[ip.String()] = strings.TrimSpace(pair[1])
 	}
-	return upsert(ro)
+	return ret, nil
 }
 
-func recordExists(ro UpsertRecordSetOpt) (bool, error) {
-	mySession := session.Must(session.NewSession())
-	r := route53.New(mySession)
-	out, err := r.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
-		HostedZoneId:          aws.String(ro.HostedZoneID),
-		StartRecordIdentifier: &ro.Identifier,
-		StartRecordName:       &ro.Hostname,
-		StartRecordType:       &ro.Type,
-	})
-	if err != nil {
-		return false, err
+// hostnames returns the hostnames of the hostname annotation.
+// Like external-dns, several hostnames are separated by commas.
+func hostnames(svc *corev1.Service) []string {
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, h := range strings.Split(svc.Annotations[HostnameAnnotationKey], ",") {
+		h = strings.TrimSpace(h)
+		key := strings.TrimSuffix(strings.ToLower(h), ".")
+		if key == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		ret = append(ret, h)
 	}
+	return ret
+}
 
-	if len(out.ResourceRecordSets) < 1 {
-		return false, errors.New("entry was not found")
+// targetIPAddresses returns every load balancer ingress and external IP address of the family served by the record type.
+// The addresses are sorted so that the record set doesn't change with their order.
+func targetIPAddresses(svc *corev1.Service, recordType string) []string {
+	candidates := append([]string{}, svc.Spec.ExternalIPs...)
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		candidates = append(candidates, ing.IP)
 	}
-	for _, rr := range out.ResourceRecordSets {
-		if rr.SetIdentifier != nil && strings.Contains(*rr.Name, ro.Hostname) && *rr.SetIdentifier == ro.Identifier {
-			return true, nil
+	ret := []string{}
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		ip := net.ParseIP(c)
+		if ip == nil || seen[ip.String()] {
+			continue
+		}
+		if (ip.To4() != nil) == (recordType != "AAAA") {
+			seen[ip.String()] = true
+			ret = append(ret, ip.String())
 		}
 	}
-	return false, nil
+	if len(ret) == 0 {
+		return nil
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// ensureRecord validates and upserts the records, and returns the ID of the change if any.
+func ensureRecord(api r53api.API, ro UpsertRecordSetOpt) (string, error) {
+	if err := validateRecordSetOpt(api, ro); err != nil {
+		return "", err
+	}
+	return upsert(api, ro)
 }
 
-func upsert(ro UpsertRecordSetOpt) error {
-	return query("UPSERT", ro)
+func recordExists(api r53api.API, ro UpsertRecordSetOpt) (bool, error) {
+	rs, err := lookupRecordSet(api, ro.HostedZoneID, ro.Hostname, ro.Type, ro.Identifier)
+	if err != nil {
+		return false, err
+	}
+	return rs != nil, nil
 }
 
-func delete(ro UpsertRecordSetOpt) error {
-	err := query("DELETE", ro)
+// upsert submits the records only when they differ from the live record sets.
+// The records taken over from external-dns are submitted with the deletion of its record sets.
+// The returned change ID is empty when nothing is submitted.
+func upsert(api r53api.API, ro UpsertRecordSetOpt) (string, error) {
+	changes, err := upsertChanges(api, ro)
+	if err != nil || len(changes) == 0 {
+		return "", err
+	}
+	logrus.Info(changes)
+	return submitChanges(api, ro.HostedZoneID, changes)
+}
+
+// upsertChanges returns the changes submitted by upsert, none when the records are up to date.
+func upsertChanges(api r53api.API, ro UpsertRecordSetOpt) ([]*route53.Change, error) {
+	changed, err := recordSetsChanged(api, ro)
+	if err != nil {
+		return nil, err
+	}
+	adopted, err := externalDNSRecordSets(api, ro)
+	if err != nil {
+		return nil, err
+	}
+	if !changed && len(adopted) == 0 {
+		return nil, nil
+	}
+	changes := []*route53.Change{}
+	for _, rs := range adopted {
+		changes = append(changes, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: rs})
+	}
+	for _, rs := range recordSets(ro) {
+		changes = append(changes, &route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: rs})
+	}
+	return changes, nil
+}
+
+// deleteRecord deletes the live records as long as their TXT record is owned by the resource of ro in this cluster.
+func deleteRecord(api r53api.API, ro UpsertRecordSetOpt) (string, error) {
+	changes, err := deleteChanges(api, ro)
+	if err != nil || len(changes) == 0 {
+		return "", err
+	}
+	logrus.Info(changes)
+	id, err := submitChanges(api, ro.HostedZoneID, changes)
 	if err != nil && strings.Contains(err.Error(), "but it was not found") {
-		return nil
+		return "", nil
 	}
-	return err
+	return id, err
+}
 
+// deleteChanges returns the changes submitted by deleteRecord.
+// A record whose TXT record was deleted outside of the controller is deleted only while it's the one applied,
+// it fails otherwise so that it's neither left behind silently nor deleted from whoever changed it.
+func deleteChanges(api r53api.API, ro UpsertRecordSetOpt) ([]*route53.Change, error) {
+	rss := recordSets(ro)
+	txt, err := liveRecordSet(api, ro.HostedZoneID, rss[1])
+	if err != nil {
+		return nil, err
+	}
+	if txt == nil {
+		rs, err := liveRecordSet(api, ro.HostedZoneID, rss[0])
+		if err != nil || rs == nil {
+			return nil, err
+		}
+		if !recordSetEqual(rss[0], rs) {
+			return nil, fmt.Errorf("%s %s has no TXT record and was changed outside of external-route53", ro.Hostname, ro.Type)
+		}
+		return []*route53.Change{{Action: aws.String("DELETE"), ResourceRecordSet: rs}}, nil
+	}
+	owner, ok := parseOwner(txt)
+	if !ok {
+		return nil, fmt.Errorf("%s %s has a TXT record not set by external-route53", ro.Hostname, ro.Type)
+	}
+	if err := validateOwner(owner, ro); err != nil {
+		return nil, err
+	}
+	rs, err := liveRecordSet(api, ro.HostedZoneID, rss[0])
+	if err != nil {
+		return nil, err
+	}
+	changes := []*route53.Change{}
+	for _, live := range []*route53.ResourceRecordSet{rs, txt} {
+		if live != nil {
+			changes = append(changes, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: live})
+		}
+	}
+	return changes, nil
 }
 
-func query(action string, ro UpsertRecordSetOpt) error {
+// recordSets builds the record set of the record and its TXT record.
+func recordSets(ro UpsertRecordSetOpt) []*route53.ResourceRecordSet {
 	var healthCheckId *string = nil
 	if ro.HealthCheckID != "" {
 		healthCheckId = &ro.HealthCheckID
 	}
-	mySession := session.Must(session.NewSession())
-	r := route53.New(mySession)
 	var ttl *int64
 	var at *route53.AliasTarget = nil
 	var rrs []*route53.ResourceRecord = nil
 	if ro.Alias {
+		aliasHostedZoneID := ro.HostedZoneID
+		if ro.AliasHostedZoneID != "" {
+			aliasHostedZoneID = ro.AliasHostedZoneID
+		}
 		at = &route53.AliasTarget{
-			EvaluateTargetHealth: aws.Bool(true),
-			HostedZoneId:         aws.String(ro.HostedZoneID),
+			EvaluateTargetHealth: aws.Bool(ro.EvaluateTargetHealth),
+			HostedZoneId:         aws.String(aliasHostedZoneID),
 			DNSName:              aws.String(ro.TargetHostname),
 		}
 		ttl = nil
-	} else {
+	} else if ro.Type == "CNAME" {
 		rrs = []*route53.ResourceRecord{
-			{Value: aws.String(ro.TargetIPAddress)},
+			{Value: aws.String(ro.TargetHostname)},
+		}
+		ttl = aws.Int64(int64(ro.TTL))
+	} else {
+		rrs = []*route53.ResourceRecord{}
+		for _, ip := range ro.TargetIPAddresses {
+			rrs = append(rrs, &route53.ResourceRecord{Value: aws.String(ip)})
 		}
 		ttl = aws.Int64(int64(ro.TTL))
 	}
-	changes := []*route53.Change{
-		{
-			Action: aws.String(action),
-			ResourceRecordSet: &route53.ResourceRecordSet{
-				Name:            aws.String(ro.Hostname),
-				AliasTarget:     at,
-				ResourceRecords: rrs,
-				SetIdentifier:   aws.String(ro.Identifier),
-				Weight:          aws.Int64(int64(ro.Weight)),
-				HealthCheckId:   healthCheckId,
-				Type:            aws.String(ro.Type),
-				TTL:             ttl,
-			},
-		},
-		{
-			Action: aws.String(action),
-			ResourceRecordSet: &route53.ResourceRecordSet{
-				Name: aws.String(fmt.Sprintf("%s%s", ro.TXTPrefix, ro.Hostname)),
-				ResourceRecords: []*route53.ResourceRecord{
-					{Value: aws.String("\"set by external-route53\"")},
-				},
-				SetIdentifier: aws.String(ro.Identifier),
-				Weight:        aws.Int64(int64(ro.Weight)),
-				HealthCheckId: healthCheckId,
-				Type:          aws.String("TXT"),
-				TTL:           aws.Int64(300),
-			},
-		},
+	rs := &route53.ResourceRecordSet{
+		Name:            aws.String(ro.Hostname),
+		AliasTarget:     at,
+		ResourceRecords: rrs,
+		SetIdentifier:   aws.String(ro.Identifier),
+		HealthCheckId:   healthCheckId,
+		Type:            aws.String(ro.Type),
+		TTL:             ttl,
 	}
-	logrus.Info(changes)
-	_, err := r.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
-		HostedZoneId: aws.String(ro.HostedZoneID),
-		ChangeBatch: &route53.ChangeBatch{
-			Comment: aws.String("change from external-route53"),
-			Changes: changes,
+	txt := &route53.ResourceRecordSet{
+		Name: aws.String(txtName(ro)),
+		ResourceRecords: []*route53.ResourceRecord{
+			{Value: aws.String(txtRecordValue(ro))},
 		},
-	})
-	if err != nil {
-		return err
+		SetIdentifier: aws.String(ro.Identifier),
+		HealthCheckId: healthCheckId,
+		Type:          aws.String("TXT"),
+		TTL:           aws.Int64(300),
 	}
-	return nil
+	// the TXT record shares the routing policy so that it coexists with the TXT records of the other identifiers
+	setRoutingPolicy(rs, ro)
+	setRoutingPolicy(txt, ro)
+	return []*route53.ResourceRecordSet{rs, txt}
 }
 
-func validateRecordSetOpt(ro UpsertRecordSetOpt) error {
+// setRoutingPolicy sets the routing policy fields of the record set. weighted by default.
+func setRoutingPolicy(rs *route53.ResourceRecordSet, ro UpsertRecordSetOpt) {
+	switch routingPolicy(ro) {
+	case "failover":
+		rs.Failover = aws.String(ro.Failover)
+	case "latency":
+		rs.Region = aws.String(ro.Region)
+	case "multivalue":
+		rs.MultiValueAnswer = aws.Bool(true)
+	case "geolocation":
+		rs.GeoLocation = &route53.GeoLocation{}
+		if ro.GeoContinentCode != "" {
+			rs.GeoLocation.ContinentCode = aws.String(ro.GeoContinentCode)
+		}
+		if ro.GeoCountryCode != "" {
+			rs.GeoLocation.CountryCode = aws.String(ro.GeoCountryCode)
+		}
+		if ro.GeoSubdivisionCode != "" {
+			rs.GeoLocation.SubdivisionCode = aws.String(ro.GeoSubdivisionCode)
+		}
+	case "geoproximity":
+		rs.GeoProximityLocation = &route53.GeoProximityLocation{Bias: aws.Int64(int64(ro.GeoProximityBias))}
+		switch {
+		case ro.GeoProximityRegion != "":
+			rs.GeoProximityLocation.AWSRegion = aws.String(ro.GeoProximityRegion)
+		case ro.GeoProximityLocalZoneGroup != "":
+			rs.GeoProximityLocation.LocalZoneGroup = aws.String(ro.GeoProximityLocalZoneGroup)
+		default:
+			rs.GeoProximityLocation.Coordinates = &route53.Coordinates{
+				Latitude:  aws.String(ro.GeoProximityLatitude),
+				Longitude: aws.String(ro.GeoProximityLongitude),
+			}
+		}
+	default:
+		rs.Weight = aws.Int64(int64(ro.Weight))
+	}
+}
+
+func routingPolicy(ro UpsertRecordSetOpt) string {
+	switch {
+	case ro.Failover != "":
+		return "failover"
+	case ro.Region != "":
+		return "latency"
+	case ro.GeoContinentCode != "" || ro.GeoCountryCode != "" || ro.GeoSubdivisionCode != "":
+		return "geolocation"
+	case ro.GeoProximityRegion != "" || ro.GeoProximityLocalZoneGroup != "" || ro.GeoProximityLatitude != "" || ro.GeoProximityLongitude != "":
+		return "geoproximity"
+	case ro.MultiValueAnswer:
+		return "multivalue"
+	}
+	return "weighted"
+}
+
+func validateRecordSetOpt(api r53api.API, ro UpsertRecordSetOpt) error {
 	if ro.HostedZoneID == "" {
 		return errors.New("hosted zone id is not found")
 	}
@@ -258,59 +715,101 @@ func validateRecordSetOpt(ro UpsertRecordSetOpt) error {
 	if !supportedType(ro.Type) {
 		return errors.New("this type is not supported")
 	}
+	if ro.Type == "CNAME" {
+		apex, err := isZoneApex(api, ro)
+		if err != nil {
+			return err
+		}
+		if apex {
+			return errors.New("CNAME record is not permitted at the zone apex")
+		}
+	}
+	if ro.Failover == route53.ResourceRecordSetFailoverPrimary && ro.HealthCheckID == "" {
+		return errors.New("PRIMARY failover record requires a health check")
+	}
+	if ro.MultiValueAnswer && (ro.Alias || ro.Type == "CNAME") {
+		return errors.New("multivalue answer record must be an A or AAAA record of IP addresses")
+	}
 	if ro.TTL < 10 {
 		return errors.New("TTL must be over 10s")
 	}
 	if ro.Alias && ro.TargetHostname == "" {
 		return errors.New("Alias record enabled but target hostname is not defined")
 	}
-	if !ro.Alias && ro.TargetIPAddress == "" {
+	if !ro.Alias && ro.Type == "CNAME" && ro.TargetHostname == "" {
+		return errors.New("CNAME record enabled but target hostname is not defined")
+	}
+	if !ro.Alias && ro.Type != "CNAME" && len(ro.TargetIPAddresses) == 0 {
 		return errors.New("Alias record disabled but target IP Address is not defined")
 	}
-	if ok, err := hasValidTxtRecord(ro); err != nil || !ok {
+	if ok, err := hasValidTxtRecord(api, ro); err != nil {
+		return err
+	} else if !ok {
 		return errors.New("This record doesn't have valid txt record. it's possible to maintain from other system")
 	}
 	return nil
 }
 
-/**
+/*
+*
 The records created by this controller has TXT record for management.
 Valid record is below:
-  1. TXT record exists. if set, it has prefix ex: prefix-example.com for managing example.com record.
-  2. TXT record has a value of the record's identifier. ex: uuid
+ 1. TXT record exists. if set, it has prefix ex: prefix-example.com for managing example.com record.
+ 2. TXT record has a value of the record's identifier. ex: uuid
+ 3. TXT record is owned by this cluster and the same resource. records of other clusters are an error.
 */
-func hasValidTxtRecord(ro UpsertRecordSetOpt) (bool, error) {
-	mySession := session.Must(session.NewSession())
-	r := route53.New(mySession)
-	txtname := fmt.Sprintf("%s%s", ro.TXTPrefix, ro.Hostname)
-	out, err := r.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
-		HostedZoneId:    aws.String(ro.HostedZoneID),
-		StartRecordName: aws.String(txtname),
-	})
+func hasValidTxtRecord(api r53api.API, ro UpsertRecordSetOpt) (bool, error) {
+	txt, err := lookupRecordSet(api, ro.HostedZoneID, txtName(ro), "TXT", ro.Identifier)
 	if err != nil {
 		return false, err
 	}
-	ret := false
-	contains := false
-	if len(out.ResourceRecordSets) == 0 {
-		return true, nil
-	}
-	for _, rs := range out.ResourceRecordSets {
-		if domainEqual(ro.Hostname, *rs.Name) {
-			contains = true
+	if txt != nil {
+		owner, ok := parseOwner(txt)
+		if !ok {
+			return false, nil
 		}
-		if domainEqual(txtname, *rs.Name) && *rs.SetIdentifier == ro.Identifier && *rs.Type == "TXT" {
-			ret = true
+		if err := validateOwner(owner, ro); err != nil {
+			return false, err
 		}
+		return true, nil
+	}
+	rs, err := lookupRecordSet(api, ro.HostedZoneID, ro.Hostname, ro.Type, ro.Identifier)
+	if err != nil {
+		return false, err
+	}
+	if rs == nil {
+		return true, nil
 	}
-	return ret || !contains, nil
+	// the records of external-dns are taken over in the migration from it
+	adopted, err := externalDNSRecordSets(api, ro)
+	return len(adopted) > 0, err
+}
+
+// txtName returns the name of the TXT record managing the record.
+// A records keep the plain prefixed name, other types add the type so that each has its own TXT record.
+func txtName(ro UpsertRecordSetOpt) string {
+	if ro.Type == "A" {
+		return fmt.Sprintf("%s%s", ro.TXTPrefix, ro.Hostname)
+	}
+	return fmt.Sprintf("%s%s-%s", ro.TXTPrefix, strings.ToLower(ro.Type), ro.Hostname)
+}
+
+func isZoneApex(api r53api.API, ro UpsertRecordSetOpt) (bool, error) {
+	name, err := hostedZoneName(api, ro.HostedZoneID)
+	if err != nil {
+		return false, err
+	}
+	return normalizeDomain(ro.Hostname) == normalizeDomain(name), nil
 }
 
 func domainEqual(s1, s2 string) bool {
 	return s1 == s2 || fmt.Sprintf("%s.", s1) == s2 || fmt.Sprintf("%s.", s2) == s1
 }
 
+func supportedRegion(region string) bool {
+	return containsString(route53.ResourceRecordSetRegion_Values(), region)
+}
+
 func supportedType(t string) bool {
-	// only A record is supported
-	return t == "A"
+	return t == "A" || t == "AAAA" || t == "CNAME"
 }
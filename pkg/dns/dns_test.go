@@ -1,35 +1,55 @@
 package dns
 
 import (
+	"fmt"
+	"os"
 	"reflect"
+	"sort"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 )
 
+func newTestAPI() *fake.Route53 {
+	api := fake.New()
+	api.AddHostedZone("Z09261522C0IVI11TUTK7", "test.takutakahashi.dev", false)
+	api.AddHostedZone("test", "example.com", false)
+	return api
+}
+
 var ROs []UpsertRecordSetOpt = []UpsertRecordSetOpt{
 	{
-		Hostname:        "external-route53.test.takutakahashi.dev.",
-		Type:            "A",
-		Identifier:      "/api/v1/namespaces/shared/services/test",
-		HealthCheckID:   "",
-		HostedZoneID:    "Z09261522C0IVI11TUTK7",
-		Weight:          10,
-		TTL:             300,
-		Alias:           false,
-		TargetIPAddress: "10.10.0.1",
+		Hostname:          "external-route53.test.takutakahashi.dev.",
+		Type:              "A",
+		Identifier:        "/api/v1/namespaces/shared/services/test",
+		HealthCheckID:     "",
+		HostedZoneID:      "Z09261522C0IVI11TUTK7",
+		Weight:            10,
+		TTL:               300,
+		Alias:             false,
+		TargetIPAddresses: []string{"10.10.0.1"},
 	},
 	{
-		Hostname:        "external-route53.test.takutakahashi.dev.",
-		Type:            "A",
-		Identifier:      "/api/v1/namespaces/beta/services/test",
-		HealthCheckID:   "",
-		HostedZoneID:    "Z09261522C0IVI11TUTK7",
-		Weight:          1,
-		TTL:             300,
-		Alias:           false,
-		TargetIPAddress: "10.10.1.1",
+		Hostname:          "external-route53.test.takutakahashi.dev.",
+		Type:              "A",
+		Identifier:        "/api/v1/namespaces/beta/services/test",
+		HealthCheckID:     "",
+		HostedZoneID:      "Z09261522C0IVI11TUTK7",
+		Weight:            1,
+		TTL:               300,
+		Alias:             false,
+		TargetIPAddresses: []string{"10.10.1.1"},
 	},
 	{
 		Hostname:       "not.test.takutakahashi.dev.",
@@ -60,7 +80,7 @@ func Test_ensureRecord(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := ensureRecord(tt.args.ro); (err != nil) != tt.wantErr {
+			if _, err := ensureRecord(newTestAPI(), tt.args.ro); (err != nil) != tt.wantErr {
 				t.Errorf("ensureRecord() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -96,9 +116,15 @@ func Test_recordExists(t *testing.T) {
 			wantErr: false,
 		},
 	}
+	api := newTestAPI()
+	for _, ro := range ROs[:2] {
+		if _, err := upsert(api, ro); err != nil {
+			t.Fatal(err)
+		}
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, err := recordExists(tt.args.ro)
+			got, err := recordExists(api, tt.args.ro)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("recordExists() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -129,14 +155,14 @@ func Test_upsert(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := upsert(tt.args.ro); (err != nil) != tt.wantErr {
+			if _, err := upsert(newTestAPI(), tt.args.ro); (err != nil) != tt.wantErr {
 				t.Errorf("upsert() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
 
-func Test_delete(t *testing.T) {
+func Test_deleteRecord(t *testing.T) {
 	type args struct {
 		ro UpsertRecordSetOpt
 	}
@@ -153,10 +179,14 @@ func Test_delete(t *testing.T) {
 			wantErr: false,
 		},
 	}
+	api := newTestAPI()
+	if _, err := upsert(api, ROs[0]); err != nil {
+		t.Fatal(err)
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			if err := delete(tt.args.ro); (err != nil) != tt.wantErr {
-				t.Errorf("delete() error = %v, wantErr %v", err, tt.wantErr)
+			if _, err := deleteRecord(api, tt.args.ro); (err != nil) != tt.wantErr {
+				t.Errorf("deleteRecord() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
@@ -169,7 +199,7 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 	tests := []struct {
 		name    string
 		args    args
-		want    UpsertRecordSetOpt
+		want    []UpsertRecordSetOpt
 		wantErr bool
 	}{
 		{
@@ -184,7 +214,7 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 							aliasAnnotationKey:         "false",
 							ttlAnnotationKey:           "10",
 							HealthCheckIdAnnotationKey: "",
-							weightAnnotationKey:        "1",
+							WeightAnnotationKey:        "1",
 							setIdentifierAnnotationKey: "test/test",
 							recordTypeAnnotationKey:    "A",
 							HealthCheckAnnotationKey:   "enable",
@@ -204,18 +234,19 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 					},
 				},
 			},
-			want: UpsertRecordSetOpt{
-				Hostname:        "test.test.example.com",
-				Type:            "A",
-				Identifier:      "test/test",
-				HealthCheckID:   "",
-				HostedZoneID:    "test",
-				Weight:          1,
-				TTL:             10,
-				Alias:           false,
-				TargetHostname:  "",
-				TargetIPAddress: "10.10.10.1",
-			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test",
+				HealthCheckID:     "",
+				HostedZoneID:      "test",
+				Weight:            1,
+				TTL:               10,
+				Alias:             false,
+				TargetHostname:    "",
+				TargetIPAddresses: []string{"10.10.10.1"},
+				TXTPrefix:         "extr53-",
+			}},
 			wantErr: false,
 		},
 		{
@@ -244,18 +275,19 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 					},
 				},
 			},
-			want: UpsertRecordSetOpt{
-				Hostname:        "test.test.example.com",
-				Type:            "A",
-				Identifier:      "test/test/aaa",
-				HealthCheckID:   "",
-				HostedZoneID:    "test",
-				Weight:          1,
-				TTL:             10,
-				Alias:           false,
-				TargetHostname:  "",
-				TargetIPAddress: "10.10.10.1",
-			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HealthCheckID:     "",
+				HostedZoneID:      "test",
+				Weight:            1,
+				TTL:               10,
+				Alias:             false,
+				TargetHostname:    "",
+				TargetIPAddresses: []string{"10.10.10.1"},
+				TXTPrefix:         "extr53-",
+			}},
 			wantErr: false,
 		},
 		{
@@ -270,7 +302,7 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 							aliasAnnotationKey:         "true",
 							ttlAnnotationKey:           "10",
 							HealthCheckIdAnnotationKey: "",
-							weightAnnotationKey:        "1",
+							WeightAnnotationKey:        "1",
 							setIdentifierAnnotationKey: "test/test",
 							recordTypeAnnotationKey:    "A",
 							HealthCheckAnnotationKey:   "enable",
@@ -284,18 +316,20 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 					},
 				},
 			},
-			want: UpsertRecordSetOpt{
-				Hostname:        "test.test.example.com",
-				Type:            "A",
-				Identifier:      "test/test",
-				HealthCheckID:   "",
-				HostedZoneID:    "test",
-				Weight:          1,
-				TTL:             10,
-				Alias:           true,
-				TargetHostname:  "test.release.example.com",
-				TargetIPAddress: "",
-			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:             "test.test.example.com",
+				Type:                 "A",
+				Identifier:           "test/test",
+				HealthCheckID:        "",
+				HostedZoneID:         "test",
+				Weight:               1,
+				TTL:                  10,
+				Alias:                true,
+				AliasHostedZoneID:    "test",
+				EvaluateTargetHealth: true,
+				TargetHostname:       "test.release.example.com",
+				TXTPrefix:            "extr53-",
+			}},
 			wantErr: false,
 		},
 		{
@@ -318,56 +352,84 @@ func Test_toUpsertRecordSetOpt(t *testing.T) {
 					},
 				},
 			},
-			want: UpsertRecordSetOpt{
-				Hostname:        "test.test.example.com",
-				Type:            "A",
-				Identifier:      "test/test/aaa",
-				HealthCheckID:   "",
-				HostedZoneID:    "test",
-				Weight:          1,
-				TTL:             10,
-				Alias:           true,
-				TargetHostname:  "test.release.example.com",
-				TargetIPAddress: "",
+			want: []UpsertRecordSetOpt{{
+				Hostname:       "test.test.example.com",
+				Type:           "CNAME",
+				Identifier:     "test/test/aaa",
+				HealthCheckID:  "",
+				HostedZoneID:   "test",
+				Weight:         1,
+				TTL:            10,
+				Alias:          false,
+				TargetHostname: "test.release.example.com",
+				TXTPrefix:      "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "dual-stack",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:  "test.test.example.com",
+							dualStackAnnotationKey: "true",
+							zoneAnnotationKey:      "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "2001:db8::1"},
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa",
+					HostedZoneID:      "test",
+					Weight:            1,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.1"},
+					TXTPrefix:         "extr53-",
+				},
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "AAAA",
+					Identifier:        "test/test/aaa",
+					HostedZoneID:      "test",
+					Weight:            1,
+					TTL:               10,
+					TargetIPAddresses: []string{"2001:db8::1"},
+					TXTPrefix:         "extr53-",
+				},
 			},
 			wantErr: false,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			got, err := toUpsertRecordSetOpt(tt.args.svc)
-			if (err != nil) != tt.wantErr {
-				t.Errorf("toUpsertRecordSetOpt() error = %v, wantErr %v", err, tt.wantErr)
-				return
-			}
-			if !reflect.DeepEqual(got, tt.want) {
-				t.Errorf("toUpsertRecordSetOpt() = %v, want %v", got, tt.want)
-			}
-		})
-	}
-}
-
-func TestEnsure(t *testing.T) {
-	type args struct {
-		svc *corev1.Service
-	}
-	tests := []struct {
-		name    string
-		args    args
-		wantErr bool
-	}{
 		{
-			name: "omitted-loadbalancer",
+			name: "dual-stack-ipv4-only",
 			args: args{
 				svc: &corev1.Service{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "test",
 						Namespace: "test",
 						Annotations: map[string]string{
-							HostnameAnnotationKey:    "omitted-lb.test.takutakahashi.dev",
-							HealthCheckAnnotationKey: "enable",
-							zoneAnnotationKey:        "Z09261522C0IVI11TUTK7",
+							HostnameAnnotationKey:  "test.test.example.com",
+							dualStackAnnotationKey: "true",
+							zoneAnnotationKey:      "test",
 						},
+						UID: "aaa",
 					},
 					Spec: corev1.ServiceSpec{
 						Type: corev1.ServiceTypeLoadBalancer,
@@ -381,34 +443,2635 @@ func TestEnsure(t *testing.T) {
 					},
 				},
 			},
+			want: []UpsertRecordSetOpt{
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa",
+					HostedZoneID:      "test",
+					Weight:            1,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.1"},
+					TXTPrefix:         "extr53-",
+				},
+			},
 			wantErr: false,
 		},
 		{
-			name: "omitted-externalName",
+			name: "multiple-addresses",
 			args: args{
 				svc: &corev1.Service{
 					ObjectMeta: metav1.ObjectMeta{
 						Name:      "test",
 						Namespace: "test",
 						Annotations: map[string]string{
-							HostnameAnnotationKey:    "test1.test.takutakahashi.dev",
-							HealthCheckAnnotationKey: "enable",
-							zoneAnnotationKey:        "Z09261522C0IVI11TUTK7",
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
 						},
+						UID: "aaa",
 					},
 					Spec: corev1.ServiceSpec{
-						Type:         corev1.ServiceTypeExternalName,
-						ExternalName: "external-route53.test.takutakahashi.dev",
+						Type:        corev1.ServiceTypeLoadBalancer,
+						ExternalIPs: []string{"10.10.20.1", "10.10.10.2"},
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.2"},
+								{IP: "10.10.10.1"},
+							},
+						},
 					},
 				},
 			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HostedZoneID:      "test",
+				Weight:            1,
+				TTL:               10,
+				TargetIPAddresses: []string{"10.10.10.1", "10.10.10.2", "10.10.20.1"},
+				TXTPrefix:         "extr53-",
+			}},
 			wantErr: false,
 		},
-	}
-	for _, tt := range tests {
-		t.Run(tt.name, func(t *testing.T) {
-			if err := Ensure(tt.args.svc); (err != nil) != tt.wantErr {
-				t.Errorf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
+		{
+			name: "multiple-hostnames",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com, global.example.com,,test.test.example.com",
+							zoneAnnotationKey:     "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa",
+					HostedZoneID:      "test",
+					Weight:            1,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.1"},
+					TXTPrefix:         "extr53-",
+				},
+				{
+					Hostname:          "global.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa",
+					HostedZoneID:      "test",
+					Weight:            1,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.1"},
+					TXTPrefix:         "extr53-",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "failover-primary",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:      "test.test.example.com",
+							zoneAnnotationKey:          "test",
+							failoverAnnotationKey:      "primary",
+							HealthCheckIdAnnotationKey: "hc",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HealthCheckID:     "hc",
+				HostedZoneID:      "test",
+				Failover:          "PRIMARY",
+				TTL:               10,
+				TargetIPAddresses: []string{"10.10.10.1"},
+				TXTPrefix:         "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "failover-primary-without-health-check",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							failoverAnnotationKey: "PRIMARY",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "failover-with-weight",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							failoverAnnotationKey: "SECONDARY",
+							WeightAnnotationKey:   "10",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "failover-unknown",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							failoverAnnotationKey: "TERTIARY",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "latency",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							regionAnnotationKey:   "ap-northeast-1",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HostedZoneID:      "test",
+				Region:            "ap-northeast-1",
+				TTL:               10,
+				TargetIPAddresses: []string{"10.10.10.1"},
+				TXTPrefix:         "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "latency-unknown-region",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							regionAnnotationKey:   "ap-northeast-9",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "latency-with-weight",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							regionAnnotationKey:   "us-east-1",
+							WeightAnnotationKey:   "10",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geolocation-subdivision",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:       "test.test.example.com",
+							zoneAnnotationKey:           "test",
+							geoCountryAnnotationKey:     "us",
+							geoSubdivisionAnnotationKey: "ca",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:           "test.test.example.com",
+				Type:               "A",
+				Identifier:         "test/test/aaa",
+				HostedZoneID:       "test",
+				GeoCountryCode:     "US",
+				GeoSubdivisionCode: "CA",
+				TTL:                10,
+				TargetIPAddresses:  []string{"10.10.10.1"},
+				TXTPrefix:          "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "geolocation-default",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:   "test.test.example.com",
+							zoneAnnotationKey:       "test",
+							geoCountryAnnotationKey: "*",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HostedZoneID:      "test",
+				GeoCountryCode:    "*",
+				TTL:               10,
+				TargetIPAddresses: []string{"10.10.10.1"},
+				TXTPrefix:         "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "geolocation-unknown-country",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:   "test.test.example.com",
+							zoneAnnotationKey:       "test",
+							geoCountryAnnotationKey: "XX",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geolocation-continent-and-country",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:     "test.test.example.com",
+							zoneAnnotationKey:         "test",
+							geoContinentAnnotationKey: "EU",
+							geoCountryAnnotationKey:   "JP",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geolocation-with-weight",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:     "test.test.example.com",
+							zoneAnnotationKey:         "test",
+							geoContinentAnnotationKey: "AS",
+							WeightAnnotationKey:       "10",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geolocation-subdivision-outside-us",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:       "test.test.example.com",
+							zoneAnnotationKey:           "test",
+							geoCountryAnnotationKey:     "JP",
+							geoSubdivisionAnnotationKey: "13",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geoproximity-coordinates",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:                "test.test.example.com",
+							zoneAnnotationKey:                    "test",
+							geoProximityCoordinatesAnnotationKey: "35.68, 139.76",
+							geoProximityBiasAnnotationKey:        "-20",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:              "test.test.example.com",
+				Type:                  "A",
+				Identifier:            "test/test/aaa",
+				HostedZoneID:          "test",
+				GeoProximityLatitude:  "35.68",
+				GeoProximityLongitude: "139.76",
+				GeoProximityBias:      -20,
+				TTL:                   10,
+				TargetIPAddresses:     []string{"10.10.10.1"},
+				TXTPrefix:             "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "geoproximity-region-and-coordinates",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:                "test.test.example.com",
+							zoneAnnotationKey:                    "test",
+							geoProximityRegionAnnotationKey:      "ap-northeast-1",
+							geoProximityCoordinatesAnnotationKey: "35.68,139.76",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geoproximity-bias-over-99",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:           "test.test.example.com",
+							zoneAnnotationKey:               "test",
+							geoProximityRegionAnnotationKey: "ap-northeast-1",
+							geoProximityBiasAnnotationKey:   "100",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "geoproximity-with-weight",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:           "test.test.example.com",
+							zoneAnnotationKey:               "test",
+							geoProximityRegionAnnotationKey: "ap-northeast-1",
+							WeightAnnotationKey:             "10",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "multivalue-answer",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:                 "test.test.example.com",
+							zoneAnnotationKey:                     "test",
+							multiValueAnswerAnnotationKey:         "true",
+							multiValueHealthCheckIDsAnnotationKey: "10.10.10.2=hc-2",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.2"},
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa/10.10.10.1",
+					HostedZoneID:      "test",
+					MultiValueAnswer:  true,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.1"},
+					TXTPrefix:         "extr53-",
+				},
+				{
+					Hostname:          "test.test.example.com",
+					Type:              "A",
+					Identifier:        "test/test/aaa/10.10.10.2",
+					HealthCheckID:     "hc-2",
+					HostedZoneID:      "test",
+					MultiValueAnswer:  true,
+					TTL:               10,
+					TargetIPAddresses: []string{"10.10.10.2"},
+					TXTPrefix:         "extr53-",
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "multivalue-answer-cname",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:         "test.test.example.com",
+							zoneAnnotationKey:             "test",
+							multiValueAnswerAnnotationKey: "true",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "test.release.example.com",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "elb-alias",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{Hostname: "test-0123456789abcdef.elb.ap-northeast-1.amazonaws.com"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:             "test.test.example.com",
+				Type:                 "A",
+				Identifier:           "test/test/aaa",
+				HostedZoneID:         "test",
+				Weight:               1,
+				TTL:                  10,
+				Alias:                true,
+				AliasHostedZoneID:    "Z31USIVHYNEOWT",
+				EvaluateTargetHealth: true,
+				TargetHostname:       "test-0123456789abcdef.elb.ap-northeast-1.amazonaws.com",
+				TXTPrefix:            "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "elb-cname",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "test.test.example.com",
+							zoneAnnotationKey:     "test",
+							aliasAnnotationKey:    "false",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{Hostname: "test-0123456789abcdef.elb.ap-northeast-1.amazonaws.com"},
+							},
+						},
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:       "test.test.example.com",
+				Type:           "CNAME",
+				Identifier:     "test/test/aaa",
+				HostedZoneID:   "test",
+				Weight:         1,
+				TTL:            10,
+				TargetHostname: "test-0123456789abcdef.elb.ap-northeast-1.amazonaws.com",
+				TXTPrefix:      "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "cloudfront-alias",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:             "test.test.example.com",
+							zoneAnnotationKey:                 "test",
+							evaluateTargetHealthAnnotationKey: "false",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "d111111abcdef8.cloudfront.net",
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:          "test.test.example.com",
+				Type:              "A",
+				Identifier:        "test/test/aaa",
+				HostedZoneID:      "test",
+				Weight:            1,
+				TTL:               10,
+				Alias:             true,
+				AliasHostedZoneID: "Z2FDTNDATAQYW2",
+				TargetHostname:    "d111111abcdef8.cloudfront.net",
+				TXTPrefix:         "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "alias-zone-override",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:  "test.test.example.com",
+							zoneAnnotationKey:      "test",
+							aliasZoneAnnotationKey: "Zother",
+							aliasAnnotationKey:     "true",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "test.other.example.org",
+					},
+				},
+			},
+			want: []UpsertRecordSetOpt{{
+				Hostname:             "test.test.example.com",
+				Type:                 "A",
+				Identifier:           "test/test/aaa",
+				HostedZoneID:         "test",
+				Weight:               1,
+				TTL:                  10,
+				Alias:                true,
+				AliasHostedZoneID:    "Zother",
+				EvaluateTargetHealth: true,
+				TargetHostname:       "test.other.example.org",
+				TXTPrefix:            "extr53-",
+			}},
+			wantErr: false,
+		},
+		{
+			name: "cname-at-apex",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "example.com",
+							zoneAnnotationKey:     "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "test.release.example.com",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "cname-at-apex-mixed-case",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey: "Example.com.",
+							zoneAnnotationKey:     "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "test.release.example.com",
+					},
+				},
+			},
+			wantErr: true,
+		},
+		{
+			name: "aaaa-without-ipv6",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:   "test.test.example.com",
+							recordTypeAnnotationKey: "AAAA",
+							zoneAnnotationKey:       "test",
+						},
+						UID: "aaa",
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := toUpsertRecordSetOpt(newTestAPI(), tt.args.svc)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("toUpsertRecordSetOpt() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			// every record is owned by the service of the case
+			for i := range tt.want {
+				tt.want[i].Owner = Owner{
+					ClusterID: OwnerID,
+					Kind:      "Service",
+					Namespace: tt.args.svc.Namespace,
+					Name:      tt.args.svc.Name,
+					UID:       string(tt.args.svc.UID),
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("toUpsertRecordSetOpt() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	type args struct {
+		svc *corev1.Service
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "omitted-loadbalancer",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:    "omitted-lb.test.takutakahashi.dev",
+							HealthCheckAnnotationKey: "enable",
+							zoneAnnotationKey:        "Z09261522C0IVI11TUTK7",
+						},
+					},
+					Spec: corev1.ServiceSpec{
+						Type: corev1.ServiceTypeLoadBalancer,
+					},
+					Status: corev1.ServiceStatus{
+						LoadBalancer: corev1.LoadBalancerStatus{
+							Ingress: []corev1.LoadBalancerIngress{
+								{IP: "10.10.10.1"},
+							},
+						},
+					},
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "omitted-externalName",
+			args: args{
+				svc: &corev1.Service{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      "test",
+						Namespace: "test",
+						Annotations: map[string]string{
+							HostnameAnnotationKey:    "test1.test.takutakahashi.dev",
+							HealthCheckAnnotationKey: "enable",
+							zoneAnnotationKey:        "Z09261522C0IVI11TUTK7",
+						},
+					},
+					Spec: corev1.ServiceSpec{
+						Type:         corev1.ServiceTypeExternalName,
+						ExternalName: "external-route53.test.takutakahashi.dev",
+					},
+				},
+			},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Ensure(newTestAPI(), tt.args.svc); (err != nil) != tt.wantErr {
+				t.Errorf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEnsureAndDeleteLastApplied(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "before.test.takutakahashi.dev",
+				zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+				WeightAnnotationKey:   "10",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{
+					{IP: "10.10.10.1"},
+				},
+			},
+		},
+	}
+	names := func() []string {
+		ret := []string{}
+		for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+			ret = append(ret, *rs.Name+"/"+*rs.Type)
+		}
+		return ret
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	// the hostname and the weight change, the old record set must be deleted as it was created
+	svc.Annotations[HostnameAnnotationKey] = "after.test.takutakahashi.dev"
+	svc.Annotations[WeightAnnotationKey] = "20"
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"after.test.takutakahashi.dev./A", "extr53-after.test.takutakahashi.dev./TXT"}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+	// the service changed again, but deletion must use what was applied
+	svc.Status.LoadBalancer.Ingress[0].IP = "10.10.10.2"
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 0 {
+		t.Errorf("Delete() records = %v, want none", got)
+	}
+	if _, ok := svc.Annotations[LastAppliedAnnotationKey]; ok {
+		t.Errorf("Delete() kept %s", LastAppliedAnnotationKey)
+	}
+}
+
+func TestEnsureDualStack(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey:  "dual.test.takutakahashi.dev",
+				dualStackAnnotationKey: "true",
+				zoneAnnotationKey:      "Z09261522C0IVI11TUTK7",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{
+					{IP: "10.10.10.1"},
+					{IP: "2001:db8::1"},
+				},
+			},
+		},
+	}
+	// the second reconcile checks that each type is owned by its own TXT record
+	for i := 0; i < 2; i++ {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := []string{}
+	for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+		got = append(got, *rs.Name+"/"+*rs.Type)
+	}
+	want := []string{
+		"dual.test.takutakahashi.dev./A",
+		"dual.test.takutakahashi.dev./AAAA",
+		"extr53-aaaa-dual.test.takutakahashi.dev./TXT",
+		"extr53-dual.test.takutakahashi.dev./TXT",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+	// the load balancer lost its IPv6 address, the A record is kept updated without the AAAA record
+	svc.Status.LoadBalancer.Ingress = []corev1.LoadBalancerIngress{{IP: "10.10.10.2"}}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	got = []string{}
+	for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+		got = append(got, *rs.Name+"/"+*rs.Type)
+	}
+	want = []string{"dual.test.takutakahashi.dev./A", "extr53-dual.test.takutakahashi.dev./TXT"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+}
+
+func TestEnsureMultipleHostnames(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "a.test.takutakahashi.dev,b.test.takutakahashi.dev",
+				zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{
+					{IP: "10.10.10.1"},
+				},
+			},
+		},
+	}
+	names := func() []string {
+		ret := []string{}
+		for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+			ret = append(ret, *rs.Name+"/"+*rs.Type)
+		}
+		return ret
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"a.test.takutakahashi.dev./A",
+		"b.test.takutakahashi.dev./A",
+		"extr53-a.test.takutakahashi.dev./TXT",
+		"extr53-b.test.takutakahashi.dev./TXT",
+	}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+	// a removed hostname is cleaned up with its TXT record
+	svc.Annotations[HostnameAnnotationKey] = "b.test.takutakahashi.dev"
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	want = []string{"b.test.takutakahashi.dev./A", "extr53-b.test.takutakahashi.dev./TXT"}
+	if got := names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(); len(got) != 0 {
+		t.Errorf("Delete() records = %v, want none", got)
+	}
+}
+
+func Test_findHostedZoneID(t *testing.T) {
+	api := fake.New()
+	api.AddHostedZone("Zpublic", "example.com", false)
+	api.AddHostedZone("Zprivate", "example.com", true)
+	api.AddHostedZone("Zsub", "sub.example.com", false)
+	api.AddHostedZone("Zorg", "example.org", false)
+	tests := []struct {
+		name      string
+		hostname  string
+		preferred string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:     "public",
+			hostname: "test.example.com",
+			want:     "Zpublic",
+		},
+		{
+			name:      "private",
+			hostname:  "test.example.com",
+			preferred: "private",
+			want:      "Zprivate",
+		},
+		{
+			name:      "longest-suffix",
+			hostname:  "test.sub.example.com.",
+			preferred: "private",
+			want:      "Zsub",
+		},
+		{
+			name:     "apex",
+			hostname: "Example.org",
+			want:     "Zorg",
+		},
+		{
+			name:     "suffix-is-not-a-label",
+			hostname: "testexample.org",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			PreferredZoneType = tt.preferred
+			defer func() { PreferredZoneType = "public" }()
+			got, err := findHostedZoneID(api, tt.hostname)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("findHostedZoneID() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("findHostedZoneID() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureDiscoversHostedZone(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "a.test.takutakahashi.dev,b.example.com",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{
+					{IP: "10.10.10.1"},
+				},
+			},
+		},
+	}
+	DomainFilter = "example.com"
+	if err := Ensure(api, svc); err == nil {
+		t.Errorf("Ensure() should refuse a hostname outside of the domain filter")
+	}
+	DomainFilter = "test.takutakahashi.dev, example.com"
+	defer func() { DomainFilter = "" }()
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := len(api.RecordSets("Z09261522C0IVI11TUTK7")); got != 2 {
+		t.Errorf("Ensure() records in test.takutakahashi.dev = %d, want 2", got)
+	}
+	if got := len(api.RecordSets("test")); got != 2 {
+		t.Errorf("Ensure() records in example.com = %d, want 2", got)
+	}
+}
+
+func TestEnsureFailover(t *testing.T) {
+	api := newTestAPI()
+	newService := func(name string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Annotations: map[string]string{
+					HostnameAnnotationKey:      "failover.test.takutakahashi.dev",
+					zoneAnnotationKey:          "Z09261522C0IVI11TUTK7",
+					HealthCheckIdAnnotationKey: "hc-" + name,
+				},
+				UID: types.UID(name),
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeLoadBalancer,
+			},
+			Status: corev1.ServiceStatus{
+				LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{
+						{IP: "10.10.10.1"},
+					},
+				},
+			},
+		}
+	}
+	primary := newService("primary")
+	secondary := newService("secondary")
+	secondary.Annotations[failoverAnnotationKey] = "SECONDARY"
+	// the weighted record of the primary is replaced by a failover one
+	if err := Ensure(api, primary); err != nil {
+		t.Fatal(err)
+	}
+	primary.Annotations[failoverAnnotationKey] = "PRIMARY"
+	for _, svc := range []*corev1.Service{primary, secondary} {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got := []string{}
+	for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+		if rs.Weight != nil {
+			t.Errorf("Ensure() kept a weighted record set %s", *rs.Name)
+		}
+		got = append(got, *rs.Name+"/"+*rs.Type+"/"+aws.StringValue(rs.Failover))
+	}
+	want := []string{
+		"extr53-failover.test.takutakahashi.dev./TXT/PRIMARY",
+		"extr53-failover.test.takutakahashi.dev./TXT/SECONDARY",
+		"failover.test.takutakahashi.dev./A/PRIMARY",
+		"failover.test.takutakahashi.dev./A/SECONDARY",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+}
+
+func Test_setRoutingPolicy(t *testing.T) {
+	tests := []struct {
+		name string
+		ro   UpsertRecordSetOpt
+		want route53.ResourceRecordSet
+	}{
+		{
+			name: "weighted",
+			ro:   UpsertRecordSetOpt{Weight: 10},
+			want: route53.ResourceRecordSet{Weight: aws.Int64(10)},
+		},
+		{
+			name: "failover",
+			ro:   UpsertRecordSetOpt{Failover: "SECONDARY"},
+			want: route53.ResourceRecordSet{Failover: aws.String("SECONDARY")},
+		},
+		{
+			name: "latency",
+			ro:   UpsertRecordSetOpt{Region: "eu-west-1"},
+			want: route53.ResourceRecordSet{Region: aws.String("eu-west-1")},
+		},
+		{
+			name: "multivalue",
+			ro:   UpsertRecordSetOpt{MultiValueAnswer: true},
+			want: route53.ResourceRecordSet{MultiValueAnswer: aws.Bool(true)},
+		},
+		{
+			name: "geolocation",
+			ro:   UpsertRecordSetOpt{GeoContinentCode: "EU"},
+			want: route53.ResourceRecordSet{GeoLocation: &route53.GeoLocation{ContinentCode: aws.String("EU")}},
+		},
+		{
+			name: "geoproximity",
+			ro:   UpsertRecordSetOpt{GeoProximityRegion: "ap-northeast-1", GeoProximityBias: 10},
+			want: route53.ResourceRecordSet{GeoProximityLocation: &route53.GeoProximityLocation{AWSRegion: aws.String("ap-northeast-1"), Bias: aws.Int64(10)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := route53.ResourceRecordSet{}
+			setRoutingPolicy(&got, tt.ro)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("setRoutingPolicy() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEnsureMultiValueAnswer(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey:         "multi.test.takutakahashi.dev",
+				zoneAnnotationKey:             "Z09261522C0IVI11TUTK7",
+				multiValueAnswerAnnotationKey: "true",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1", "10.10.10.2"},
+		},
+	}
+	identifiers := func() []string {
+		ret := []string{}
+		for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+			if !aws.BoolValue(rs.MultiValueAnswer) {
+				t.Errorf("Ensure() created %s without multivalue answer", *rs.Name)
+			}
+			ret = append(ret, *rs.Type+"/"+*rs.SetIdentifier)
+		}
+		return ret
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	// a removed target is deleted with its TXT record
+	svc.Spec.ExternalIPs = []string{"10.10.10.2"}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"TXT/test/test/aaa/10.10.10.2", "A/test/test/aaa/10.10.10.2"}
+	if got := identifiers(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() records = %v, want %v", got, want)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	api := newTestAPI()
+	newService := func(name string, annotations map[string]string) *corev1.Service {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Annotations: map[string]string{
+					zoneAnnotationKey: "Z09261522C0IVI11TUTK7",
+					ttlAnnotationKey:  "60",
+				},
+				UID: types.UID(name),
+			},
+			Spec: corev1.ServiceSpec{
+				Type: corev1.ServiceTypeLoadBalancer,
+			},
+			Status: corev1.ServiceStatus{
+				LoadBalancer: corev1.LoadBalancerStatus{
+					Ingress: []corev1.LoadBalancerIngress{
+						{IP: "10.10.10.1"},
+					},
+				},
+			},
+		}
+		for k, v := range annotations {
+			svc.Annotations[k] = v
+		}
+		return svc
+	}
+	weighted := newService("weighted", map[string]string{
+		HostnameAnnotationKey: "weighted.test.takutakahashi.dev",
+		WeightAnnotationKey:   "10",
+	})
+	// stable takes over the answers of weighted
+	stable := newService("stable", map[string]string{
+		HostnameAnnotationKey: "weighted.test.takutakahashi.dev",
+		WeightAnnotationKey:   "10",
+	})
+	// the answers of sole can't go to another record
+	sole := newService("sole", map[string]string{
+		HostnameAnnotationKey: "sole.test.takutakahashi.dev",
+		WeightAnnotationKey:   "10",
+	})
+	failover := newService("failover", map[string]string{
+		HostnameAnnotationKey:      "failover.test.takutakahashi.dev",
+		failoverAnnotationKey:      "PRIMARY",
+		HealthCheckIdAnnotationKey: "hc",
+	})
+	if err := Ensure(api, stable); err != nil {
+		t.Fatal(err)
+	}
+	for _, svc := range []*corev1.Service{weighted, sole, failover} {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+		ttl, err := Drain(api, svc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ttl != time.Minute {
+			t.Errorf("Drain() = %v, want %v", ttl, time.Minute)
+		}
+	}
+	records := func() []string {
+		ret := []string{}
+		for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+			ret = append(ret, fmt.Sprintf("%s/%s/%s/%d", *rs.Name, *rs.Type, aws.StringValue(rs.SetIdentifier), aws.Int64Value(rs.Weight)))
+		}
+		sort.Strings(ret)
+		return ret
+	}
+	// the weighted records are kept with weight 0, the failover ones and the sole weighted ones are withdrawn
+	want := []string{
+		"extr53-weighted.test.takutakahashi.dev./TXT/test/stable/stable/10",
+		"extr53-weighted.test.takutakahashi.dev./TXT/test/weighted/weighted/0",
+		"weighted.test.takutakahashi.dev./A/test/stable/stable/10",
+		"weighted.test.takutakahashi.dev./A/test/weighted/weighted/0",
+	}
+	if got := records(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Drain() records = %v, want %v", got, want)
+	}
+	// the drained records are deleted as they are now
+	for _, svc := range []*corev1.Service{weighted, sole, failover} {
+		if err := Delete(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want = []string{
+		"extr53-weighted.test.takutakahashi.dev./TXT/test/stable/stable/10",
+		"weighted.test.takutakahashi.dev./A/test/stable/stable/10",
+	}
+	if got := records(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Delete() records = %v, want %v", got, want)
+	}
+}
+
+func TestDetectDrift(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "drift.test.takutakahashi.dev",
+				zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeLoadBalancer,
+		},
+		Status: corev1.ServiceStatus{
+			LoadBalancer: corev1.LoadBalancerStatus{
+				Ingress: []corev1.LoadBalancerIngress{
+					{IP: "10.10.10.1"},
+				},
+			},
+		},
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if drifts, err := DetectDrift(api, svc); err != nil || len(drifts) != 0 {
+		t.Fatalf("DetectDrift() = %v, %v right after Ensure()", drifts, err)
+	}
+	// the address is changed and the TXT record is deleted by hand
+	var changed, deleted *route53.ResourceRecordSet
+	for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+		if *rs.Type == "TXT" {
+			deleted = rs
+		} else {
+			changed = rs
+		}
+	}
+	changed.ResourceRecords[0].Value = aws.String("10.10.10.2")
+	for _, c := range []*route53.Change{
+		{Action: aws.String("DELETE"), ResourceRecordSet: deleted},
+		{Action: aws.String("UPSERT"), ResourceRecordSet: changed},
+	} {
+		if _, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+			HostedZoneId: aws.String("Z09261522C0IVI11TUTK7"),
+			ChangeBatch:  &route53.ChangeBatch{Changes: []*route53.Change{c}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the changes by hand are seen after the cached record sets are refreshed
+	invalidateRecordSets(api, "Z09261522C0IVI11TUTK7")
+	drifts, err := DetectDrift(api, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, d := range drifts {
+		got = append(got, d.String())
+	}
+	want := []string{
+		"drift.test.takutakahashi.dev A test/test/aaa (changed)",
+		"extr53-drift.test.takutakahashi.dev TXT test/test/aaa (missing)",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DetectDrift() = %v, want %v", got, want)
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if drifts, err := DetectDrift(api, svc); err != nil || len(drifts) != 0 {
+		t.Errorf("DetectDrift() = %v, %v after Ensure() restored the records", drifts, err)
+	}
+}
+
+// countingAPI counts the change batches submitted to Route53, and fails them with err if set.
+type countingAPI struct {
+	*fake.Route53
+	changes int
+	err     error
+}
+
+func (c *countingAPI) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	c.changes++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.Route53.ChangeResourceRecordSets(in)
+}
+
+func TestEnsureSkipsUnchangedRecords(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "noop.test.takutakahashi.dev",
+				zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.2", "10.10.10.1"},
+		},
+	}
+	for i := 0; i < 3; i++ {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.changes != 1 {
+		t.Errorf("Ensure() submitted %d change batches, want 1", api.changes)
+	}
+	svc.Spec.ExternalIPs = []string{"10.10.10.3"}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.changes != 2 {
+		t.Errorf("Ensure() submitted %d change batches after the address changed, want 2", api.changes)
+	}
+}
+
+func TestEnsureGeoProximity(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	for _, region := range []string{"ap-northeast-1", "us-east-1"} {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      region,
+				Namespace: "test",
+				Annotations: map[string]string{
+					HostnameAnnotationKey:           "geo.test.takutakahashi.dev",
+					zoneAnnotationKey:               "Z09261522C0IVI11TUTK7",
+					geoProximityRegionAnnotationKey: region,
+				},
+				UID: "aaa",
+			},
+			Spec: corev1.ServiceSpec{
+				Type:        corev1.ServiceTypeLoadBalancer,
+				ExternalIPs: []string{"10.10.10.1"},
+			},
+		}
+		// the record without a bias is unchanged on the second reconcile
+		for i := 0; i < 2; i++ {
+			if err := Ensure(api, svc); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if api.changes != 2 {
+		t.Errorf("Ensure() submitted %d change batches, want 2", api.changes)
+	}
+	got := []string{}
+	for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+		if *rs.Type == "A" {
+			got = append(got, aws.StringValue(rs.GeoProximityLocation.AWSRegion))
+		}
+	}
+	sort.Strings(got)
+	if want := []string{"ap-northeast-1", "us-east-1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Ensure() geoproximity regions = %v, want %v", got, want)
+	}
+}
+
+func TestEnsureSubmitsOneChangeBatch(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey:  "a.test.takutakahashi.dev,b.test.takutakahashi.dev",
+				dualStackAnnotationKey: "true",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1", "2001:db8::1"},
+		},
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.changes != 1 {
+		t.Errorf("Ensure() submitted %d change batches, want 1", api.changes)
+	}
+	// the deletion of the records of the removed hostname goes with the upsert of the new one
+	svc.Annotations[HostnameAnnotationKey] = "a.test.takutakahashi.dev,c.test.takutakahashi.dev"
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.changes != 2 {
+		t.Errorf("Ensure() submitted %d change batches after the hostname changed, want 2", api.changes)
+	}
+	if got := len(api.RecordSets("Z09261522C0IVI11TUTK7")); got != 8 {
+		t.Errorf("Ensure() left %d record sets, want 8", got)
+	}
+}
+
+func TestDrainAndDeleteSubmitOneChangeBatch(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey:  "a.test.takutakahashi.dev,b.test.takutakahashi.dev",
+				dualStackAnnotationKey: "true",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1", "2001:db8::1"},
+		},
+	}
+	// other keeps the weighted sets answering while svc is drained
+	other := svc.DeepCopy()
+	other.Name, other.UID = "other", "bbb"
+	for _, svc := range []*corev1.Service{other, svc} {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := Drain(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.changes != 3 {
+		t.Errorf("Drain() submitted %d change batches, want 1", api.changes-2)
+	}
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.changes != 4 {
+		t.Errorf("Delete() submitted %d change batches, want 1", api.changes-3)
+	}
+	if got := len(api.RecordSets("Z09261522C0IVI11TUTK7")); got != 8 {
+		t.Errorf("Delete() left %d record sets, want the 8 of other", got)
+	}
+}
+
+func TestEnsureWildcard(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "*.apps.test.takutakahashi.dev",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1"},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Route53 returns the wildcard as \052, the records are found by the name of the service
+	if api.changes != 1 {
+		t.Errorf("Ensure() submitted %d change batches, want 1", api.changes)
+	}
+	drifts, err := DetectDrift(api, svc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drifts) != 0 {
+		t.Errorf("DetectDrift() = %v, want none", drifts)
+	}
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.RecordSets("Z09261522C0IVI11TUTK7"); len(got) != 0 {
+		t.Errorf("Delete() left %v", got)
+	}
+}
+
+func TestDeleteWithoutTxtRecord(t *testing.T) {
+	tests := []struct {
+		name string
+		// value is the value the record is changed to outside of the controller, if any
+		value   string
+		wantErr bool
+		want    int
+	}{
+		{
+			name: "applied",
+			want: 0,
+		},
+		{
+			name:    "changed",
+			value:   "10.10.10.2",
+			wantErr: true,
+			want:    1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := newTestAPI()
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						HostnameAnnotationKey: "notxt.test.takutakahashi.dev",
+					},
+					UID: "aaa",
+				},
+				Spec: corev1.ServiceSpec{
+					Type:        corev1.ServiceTypeLoadBalancer,
+					ExternalIPs: []string{"10.10.10.1"},
+				},
+			}
+			if err := Ensure(api, svc); err != nil {
+				t.Fatal(err)
+			}
+			changes := []*route53.Change{}
+			for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+				switch {
+				case *rs.Type == "TXT":
+					changes = append(changes, &route53.Change{Action: aws.String("DELETE"), ResourceRecordSet: rs})
+				case tt.value != "":
+					rs.ResourceRecords[0].Value = aws.String(tt.value)
+					changes = append(changes, &route53.Change{Action: aws.String("UPSERT"), ResourceRecordSet: rs})
+				}
+			}
+			if _, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+				HostedZoneId: aws.String("Z09261522C0IVI11TUTK7"),
+				ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			invalidateRecordSets(api, "Z09261522C0IVI11TUTK7")
+			if err := Delete(api, svc); (err != nil) != tt.wantErr {
+				t.Errorf("Delete() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(api.RecordSets("Z09261522C0IVI11TUTK7")); got != tt.want {
+				t.Errorf("Delete() left %d record sets, want %d", got, tt.want)
+			}
+			if _, ok := svc.Annotations[LastAppliedAnnotationKey]; ok != tt.wantErr {
+				t.Errorf("Delete() kept the last-applied annotation = %v, want %v", ok, tt.wantErr)
+			}
+		})
+	}
+}
+
+// listingAPI counts the pages of record sets listed from Route53.
+type listingAPI struct {
+	*fake.Route53
+	lists int
+}
+
+func (l *listingAPI) ListResourceRecordSets(in *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	l.lists++
+	return l.Route53.ListResourceRecordSets(in)
+}
+
+func TestRecordCache(t *testing.T) {
+	api := &listingAPI{Route53: newTestAPI()}
+	changes := []*route53.Change{}
+	for i := 0; i < 400; i++ {
+		changes = append(changes, &route53.Change{
+			Action: aws.String("CREATE"),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(fmt.Sprintf("host%03d.test.takutakahashi.dev", i)),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.10.10.1")}},
+			},
+		})
+	}
+	if _, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z09261522C0IVI11TUTK7"),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"host000.test.takutakahashi.dev", "host399.test.takutakahashi.dev."} {
+		rs, err := lookupRecordSet(api, "Z09261522C0IVI11TUTK7", name, "A", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if rs == nil {
+			t.Errorf("lookupRecordSet() = nil for %s", name)
+		}
+	}
+	if api.lists != 2 {
+		t.Errorf("ListResourceRecordSets() is called %d times, want 2 pages listed once", api.lists)
+	}
+	ro := UpsertRecordSetOpt{
+		Hostname:          "cached.test.takutakahashi.dev",
+		Type:              "A",
+		Identifier:        "aaa",
+		HostedZoneID:      "Z09261522C0IVI11TUTK7",
+		Weight:            1,
+		TTL:               300,
+		TargetIPAddresses: []string{"10.10.10.1"},
+		TXTPrefix:         "extr53-",
+	}
+	if _, err := ensureRecord(api, ro); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := recordExists(api, ro); err != nil || !exists {
+		t.Errorf("recordExists() = %v, %v after the record is written", exists, err)
+	}
+	if api.lists != 2 {
+		t.Errorf("ListResourceRecordSets() is called %d times, want the cache updated with the write", api.lists)
+	}
+	// a rejected change may come from out of date record sets
+	missing := []*route53.Change{{Action: aws.String("DELETE"), ResourceRecordSet: recordSets(ro)[0]}}
+	missing[0].ResourceRecordSet.Name = aws.String("missing.test.takutakahashi.dev")
+	if _, err := submitChanges(api, ro.HostedZoneID, missing); err == nil {
+		t.Fatal("submitChanges() of a missing record succeeded")
+	}
+	if _, err := recordExists(api, ro); err != nil {
+		t.Fatal(err)
+	}
+	if api.lists != 4 {
+		t.Errorf("ListResourceRecordSets() is called %d times, want the zone listed again after the rejected change", api.lists)
+	}
+}
+
+// blockingAPI blocks the listing of the record sets of the hosted zone test until release is closed.
+type blockingAPI struct {
+	*fake.Route53
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAPI) ListResourceRecordSets(in *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	if aws.StringValue(in.HostedZoneId) == "test" {
+		close(b.listing)
+		<-b.release
+	}
+	return b.Route53.ListResourceRecordSets(in)
+}
+
+func TestRecordCacheLocksPerZone(t *testing.T) {
+	api := &blockingAPI{Route53: newTestAPI(), listing: make(chan struct{}), release: make(chan struct{})}
+	done := make(chan error)
+	go func() {
+		_, err := lookupRecordSet(api, "test", "a.example.com", "A", "")
+		done <- err
+	}()
+	<-api.listing
+	// the lookups in the other hosted zones don't wait for the listing of test
+	if _, err := lookupRecordSet(api, "Z09261522C0IVI11TUTK7", "a.test.takutakahashi.dev", "A", ""); err != nil {
+		t.Fatal(err)
+	}
+	close(api.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestEnsureListsRecordSetsOnce(t *testing.T) {
+	api := &listingAPI{Route53: newTestAPI()}
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey:  "a.test.takutakahashi.dev,b.test.takutakahashi.dev",
+				dualStackAnnotationKey: "true",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1", "2001:db8::1"},
+		},
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	// a reconcile of the unchanged service once the cache expired compares the 8 record sets with one listing
+	invalidateRecordSets(api, "Z09261522C0IVI11TUTK7")
+	api.lists = 0
+	if _, err := DetectDrift(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if api.lists != 1 {
+		t.Errorf("ListResourceRecordSets() is called %d times in a reconcile, want 1", api.lists)
+	}
+}
+
+func TestSubmitChanges(t *testing.T) {
+	change := func(action, name string) []*route53.Change {
+		return []*route53.Change{{
+			Action: aws.String(action),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String(name),
+				Type:            aws.String("A"),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.10.10.1")}},
+			},
+		}}
+	}
+	tests := []struct {
+		name    string
+		changes [][]*route53.Change
+		// err fails every change batch
+		err         error
+		wantErrs    []bool
+		wantBatches int
+	}{
+		{
+			name: "coalesced",
+			changes: [][]*route53.Change{
+				change("UPSERT", "batch1.test.takutakahashi.dev"),
+				change("UPSERT", "batch2.test.takutakahashi.dev"),
+				change("UPSERT", "batch3.test.takutakahashi.dev"),
+			},
+			wantErrs:    []bool{false, false, false},
+			wantBatches: 1,
+		},
+		{
+			name: "one-invalid",
+			changes: [][]*route53.Change{
+				change("UPSERT", "batch1.test.takutakahashi.dev"),
+				change("DELETE", "missing.test.takutakahashi.dev"),
+				change("UPSERT", "batch3.test.takutakahashi.dev"),
+			},
+			wantErrs:    []bool{false, true, false},
+			wantBatches: 4,
+		},
+		{
+			// the changes aren't submitted one by one while Route53 is throttling
+			name: "throttled",
+			changes: [][]*route53.Change{
+				change("UPSERT", "batch1.test.takutakahashi.dev"),
+				change("UPSERT", "batch2.test.takutakahashi.dev"),
+				change("UPSERT", "batch3.test.takutakahashi.dev"),
+			},
+			err:         awserr.New("Throttling", "Rate exceeded", nil),
+			wantErrs:    []bool{true, true, true},
+			wantBatches: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := &countingAPI{Route53: newTestAPI(), err: tt.err}
+			errs := make([]error, len(tt.changes))
+			var wg sync.WaitGroup
+			for i, c := range tt.changes {
+				wg.Add(1)
+				go func(i int, c []*route53.Change) {
+					defer wg.Done()
+					_, errs[i] = submitChanges(api, "Z09261522C0IVI11TUTK7", c)
+				}(i, c)
+			}
+			wg.Wait()
+			for i, err := range errs {
+				if (err != nil) != tt.wantErrs[i] {
+					t.Errorf("submitChanges() of the change %d error = %v, wantErr %v", i, err, tt.wantErrs[i])
+				}
+			}
+			if api.changes != tt.wantBatches {
+				t.Errorf("submitChanges() submitted %d change batches, want %d", api.changes, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func Test_splitChangeRequests(t *testing.T) {
+	request := func(records, length int) *changeRequest {
+		rs := &route53.ResourceRecordSet{Name: aws.String("split.test.takutakahashi.dev"), Type: aws.String("TXT")}
+		for i := 0; i < records; i++ {
+			rs.ResourceRecords = append(rs.ResourceRecords, &route53.ResourceRecord{Value: aws.String(strings.Repeat("a", length))})
+		}
+		return &changeRequest{changes: []*route53.Change{{Action: aws.String("CREATE"), ResourceRecordSet: rs}}}
+	}
+	tests := []struct {
+		name string
+		reqs []*changeRequest
+		want []int
+	}{
+		{
+			name: "within-limits",
+			reqs: []*changeRequest{request(1, 10), request(1, 10), request(1, 10)},
+			want: []int{3},
+		},
+		{
+			name: "records",
+			reqs: []*changeRequest{request(600, 1), request(300, 1), request(200, 1)},
+			want: []int{2, 1},
+		},
+		{
+			name: "characters",
+			reqs: []*changeRequest{request(1, 20000), request(1, 20000)},
+			want: []int{1, 1},
+		},
+		{
+			name: "request-over-limits",
+			reqs: []*changeRequest{request(1, 10), request(1200, 1), request(1, 10)},
+			want: []int{1, 1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			for _, batch := range splitChangeRequests(tt.reqs) {
+				got = append(got, len(batch))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("splitChangeRequests() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckPropagation(t *testing.T) {
+	api := newTestAPI()
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+			Annotations: map[string]string{
+				HostnameAnnotationKey: "propagation.test.takutakahashi.dev",
+				zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+			},
+			UID: "aaa",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1"},
+		},
+	}
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Annotations[ChangeStatusAnnotationKey] != "PENDING" || svc.Annotations[ChangeIDsAnnotationKey] == "" {
+		t.Fatalf("Ensure() annotations = %v, want a pending change", svc.Annotations)
+	}
+	observed := propagationObservations(t)
+	if synced, err := CheckPropagation(api, svc); err != nil || synced {
+		t.Errorf("CheckPropagation() = %v, %v before the change is INSYNC", synced, err)
+	}
+	api.SyncChanges()
+	if synced, err := CheckPropagation(api, svc); err != nil || !synced {
+		t.Errorf("CheckPropagation() = %v, %v after the change is INSYNC", synced, err)
+	}
+	if svc.Annotations[ChangeStatusAnnotationKey] != "INSYNC" || svc.Annotations[ChangeInSyncAtAnnotationKey] == "" {
+		t.Errorf("CheckPropagation() annotations = %v, want the change INSYNC", svc.Annotations)
+	}
+	if got := propagationObservations(t) - observed; got != 1 {
+		t.Errorf("CheckPropagation() observed %d propagation times, want 1", got)
+	}
+	// nothing is submitted for the unchanged records
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if svc.Annotations[ChangeStatusAnnotationKey] != "INSYNC" {
+		t.Errorf("Ensure() of the unchanged records set the change status %v", svc.Annotations[ChangeStatusAnnotationKey])
+	}
+	// the propagation time isn't known when the change was not polled PENDING on time, e.g. after a restart
+	for name, polledAt := range map[string]time.Duration{"not polled": 0, "polled late": -time.Hour} {
+		svc.Annotations[HostnameAnnotationKey] = strings.ReplaceAll(name, " ", "-") + ".test.takutakahashi.dev"
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+		if polledAt != 0 {
+			setPendingSeen(svc.Annotations[ChangeIDsAnnotationKey], time.Now().Add(polledAt))
+		}
+		api.SyncChanges()
+		observed := propagationObservations(t)
+		if synced, err := CheckPropagation(api, svc); err != nil || !synced {
+			t.Errorf("CheckPropagation() = %v, %v after the change is INSYNC", synced, err)
+		}
+		if got := propagationObservations(t) - observed; got != 0 {
+			t.Errorf("CheckPropagation() observed %d propagation times of the change %s", got, name)
+		}
+	}
+}
+
+func propagationObservations(t *testing.T) uint64 {
+	m := dto.Metric{}
+	if err := metrics.ChangePropagationDuration.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestListManagedRecords(t *testing.T) {
+	api := newTestAPI()
+	for _, hostname := range []string{"gc1.test.takutakahashi.dev", "gc2.test.takutakahashi.dev", "gc3.example.com"} {
+		name := strings.Split(hostname, ".")[0]
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Annotations: map[string]string{
+					HostnameAnnotationKey: hostname,
+				},
+				UID: types.UID(name + "-uid"),
+			},
+			Spec: corev1.ServiceSpec{
+				Type:        corev1.ServiceTypeLoadBalancer,
+				ExternalIPs: []string{"10.10.10.1"},
+			},
+		}
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the hosted zones managed by the controller must be set
+	if _, err := ListManagedRecords(api); err == nil {
+		t.Errorf("ListManagedRecords() without HOSTED_ZONE_ID nor DomainFilter succeeded")
+	}
+	// the records in the other hosted zones, gc3.example.com, are not listed
+	DomainFilter = "test.takutakahashi.dev"
+	defer func() { DomainFilter = "" }()
+	// records of other systems and of custom identifiers are not listed
+	custom := UpsertRecordSetOpt{
+		Hostname:          "custom.test.takutakahashi.dev",
+		Type:              "A",
+		Identifier:        "custom",
+		HostedZoneID:      "Z09261522C0IVI11TUTK7",
+		Weight:            1,
+		TTL:               300,
+		TargetIPAddresses: []string{"10.10.10.1"},
+		TXTPrefix:         "extr53-",
+	}
+	if _, err := upsert(api, custom); err != nil {
+		t.Fatal(err)
+	}
+	// the legacy TXT records don't tell the cluster owning the records
+	legacy := custom
+	legacy.Hostname = "legacy.test.takutakahashi.dev"
+	legacy.Identifier = "test/legacy/legacy-uid"
+	if _, err := upsert(api, legacy); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z09261522C0IVI11TUTK7"),
+		ChangeBatch: &route53.ChangeBatch{Changes: []*route53.Change{{
+			Action: aws.String("CREATE"),
+			ResourceRecordSet: &route53.ResourceRecordSet{
+				Name:            aws.String("other.test.takutakahashi.dev"),
+				Type:            aws.String("A"),
+				SetIdentifier:   aws.String("test/other/other-uid"),
+				Weight:          aws.Int64(1),
+				TTL:             aws.Int64(300),
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String("10.10.10.1")}},
+			},
+		}}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	invalidateRecordSets(api, "Z09261522C0IVI11TUTK7")
+	managed, err := ListManagedRecords(api)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, m := range managed {
+		names := []string{}
+		for _, rs := range m.RecordSets {
+			names = append(names, *rs.Name+" "+*rs.Type)
+		}
+		got = append(got, fmt.Sprintf("%s/%s/%s: %s", m.Namespace, m.Name, m.UID, strings.Join(names, ", ")))
+	}
+	want := []string{
+		"test/gc1/gc1-uid: extr53-gc1.test.takutakahashi.dev. TXT, gc1.test.takutakahashi.dev. A",
+		"test/gc2/gc2-uid: extr53-gc2.test.takutakahashi.dev. TXT, gc2.test.takutakahashi.dev. A",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ListManagedRecords() = %v, want %v", got, want)
+	}
+	CollectLegacyRecords = true
+	if managed, err := ListManagedRecords(api); err != nil || len(managed) != 3 || managed[2].Name != "legacy" {
+		t.Errorf("ListManagedRecords() = %v, %v with CollectLegacyRecords", managed, err)
+	}
+	CollectLegacyRecords = false
+	if err := DeleteManagedRecords(api, managed[0]); err != nil {
+		t.Fatal(err)
+	}
+	if managed, err := ListManagedRecords(api); err != nil || len(managed) != 1 || managed[0].Name != "gc2" {
+		t.Errorf("ListManagedRecords() = %v, %v after the records of gc1 are deleted", managed, err)
+	}
+}
+
+func Test_parseOwner(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		want   Owner
+		wantOk bool
+	}{
+		{
+			name:   "owner",
+			value:  Owner{ClusterID: "a", Kind: "Service", Namespace: "test", Name: "test", UID: "aaa"}.encode(),
+			want:   Owner{ClusterID: "a", Kind: "service", Namespace: "test", Name: "test", UID: "aaa"},
+			wantOk: true,
+		},
+		{
+			name:   "long",
+			value:  Owner{ClusterID: "a", Kind: "Service", Namespace: strings.Repeat("n", 63), Name: strings.Repeat("s", 63), UID: "5f2b6a3e-1c4d-4e8f-9a0b-7c6d5e4f3a2b"}.encode(),
+			want:   Owner{ClusterID: "a", Kind: "service", Namespace: strings.Repeat("n", 63), Name: strings.Repeat("s", 63), UID: "5f2b6a3e-1c4d-4e8f-9a0b-7c6d5e4f3a2b"},
+			wantOk: true,
+		},
+		{
+			name:   "legacy",
+			value:  "\"set by external-route53\"",
+			want:   Owner{},
+			wantOk: true,
+		},
+		{
+			name:   "external-dns",
+			value:  "\"heritage=external-dns,external-dns/owner=default,external-dns/resource=service/test/test\"",
+			want:   Owner{},
+			wantOk: false,
+		},
+		{
+			name:   "other",
+			value:  "\"v=spf1 -all\"",
+			want:   Owner{},
+			wantOk: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseOwner(&route53.ResourceRecordSet{
+				ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(tt.value)}},
+			})
+			if got != tt.want || ok != tt.wantOk {
+				t.Errorf("parseOwner() = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestEnsureOwnership(t *testing.T) {
+	defer func(id string) { OwnerID = id }(OwnerID)
+	api := newTestAPI()
+	service := func(name string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Annotations: map[string]string{
+					HostnameAnnotationKey:      "owner.test.takutakahashi.dev",
+					zoneAnnotationKey:          "Z09261522C0IVI11TUTK7",
+					setIdentifierAnnotationKey: "shared",
+				},
+				UID: types.UID(name + "-uid"),
+			},
+			Spec: corev1.ServiceSpec{
+				Type:        corev1.ServiceTypeLoadBalancer,
+				ExternalIPs: []string{"10.10.10.1"},
+			},
+		}
+	}
+	// the legacy records are taken over and their TXT record gets the owner
+	legacy := UpsertRecordSetOpt{
+		Hostname:          "owner.test.takutakahashi.dev",
+		Type:              "A",
+		Identifier:        "shared",
+		HostedZoneID:      "Z09261522C0IVI11TUTK7",
+		Weight:            1,
+		TTL:               10,
+		TargetIPAddresses: []string{"10.10.10.1"},
+		TXTPrefix:         "extr53-",
+	}
+	if _, err := upsert(api, legacy); err != nil {
+		t.Fatal(err)
+	}
+	OwnerID = "a"
+	svc := service("test")
+	if err := Ensure(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	txt, err := lookupRecordSet(api, "Z09261522C0IVI11TUTK7", "extr53-owner.test.takutakahashi.dev", "TXT", "shared")
+	if err != nil || txt == nil {
+		t.Fatalf("lookupRecordSet() = %v, %v", txt, err)
+	}
+	if owner, _ := parseOwner(txt); owner.ClusterID != "a" || owner.Name != "test" {
+		t.Errorf("the TXT record is owned by %v, want the service test in the cluster a", owner)
+	}
+	// another service in the cluster doesn't take over the records
+	if err := Ensure(api, service("other")); err == nil {
+		t.Errorf("Ensure() of another service succeeded")
+	}
+	// another cluster neither updates nor deletes the records
+	OwnerID = "b"
+	if err := Ensure(api, svc.DeepCopy()); err == nil {
+		t.Errorf("Ensure() in another cluster succeeded")
+	}
+	if err := Delete(api, svc.DeepCopy()); err == nil {
+		t.Errorf("Delete() in another cluster succeeded")
+	}
+	if len(api.RecordSets("Z09261522C0IVI11TUTK7")) != 2 {
+		t.Errorf("the records are changed by another cluster: %v", api.RecordSets("Z09261522C0IVI11TUTK7"))
+	}
+	os.Setenv("HOSTED_ZONE_ID", "Z09261522C0IVI11TUTK7")
+	if managed, err := ListManagedRecords(api); err != nil || len(managed) != 0 {
+		t.Errorf("ListManagedRecords() in another cluster = %v, %v", managed, err)
+	}
+	os.Unsetenv("HOSTED_ZONE_ID")
+	OwnerID = "a"
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if len(api.RecordSets("Z09261522C0IVI11TUTK7")) != 0 {
+		t.Errorf("Delete() left the records: %v", api.RecordSets("Z09261522C0IVI11TUTK7"))
+	}
+}
+
+func TestEnsureLongOwner(t *testing.T) {
+	api := &countingAPI{Route53: newTestAPI()}
+	// the longest namespace and name with a UID take more than the 255 characters of a character string of a TXT record
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      strings.Repeat("s", 63),
+			Namespace: strings.Repeat("n", 63),
+			Annotations: map[string]string{
+				HostnameAnnotationKey:      "long.test.takutakahashi.dev",
+				setIdentifierAnnotationKey: "long",
+			},
+			UID: "5f2b6a3e-1c4d-4e8f-9a0b-7c6d5e4f3a2b",
+		},
+		Spec: corev1.ServiceSpec{
+			Type:        corev1.ServiceTypeLoadBalancer,
+			ExternalIPs: []string{"10.10.10.1"},
+		},
+	}
+	for i := 0; i < 2; i++ {
+		if err := Ensure(api, svc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if api.changes != 1 {
+		t.Errorf("Ensure() submitted %d change batches, want 1", api.changes)
+	}
+	txt, err := lookupRecordSet(api, "Z09261522C0IVI11TUTK7", "extr53-long.test.takutakahashi.dev", "TXT", "long")
+	if err != nil || txt == nil {
+		t.Fatalf("lookupRecordSet() = %v, %v", txt, err)
+	}
+	if owner, _ := parseOwner(txt); owner.Namespace != svc.Namespace || owner.Name != svc.Name || owner.UID != string(svc.UID) {
+		t.Errorf("the TXT record is owned by %v, want the service", owner)
+	}
+	if err := Delete(api, svc); err != nil {
+		t.Fatal(err)
+	}
+	if got := api.RecordSets("Z09261522C0IVI11TUTK7"); len(got) != 0 {
+		t.Errorf("Delete() left %v", got)
+	}
+}
+
+func TestEnsureAdoptsExternalDNSRecords(t *testing.T) {
+	defer func(id string) { AdoptExternalDNSOwnerID = id }(AdoptExternalDNSOwnerID)
+	record := func(name, recordType, identifier, value string) *route53.ResourceRecordSet {
+		rs := &route53.ResourceRecordSet{
+			Name:            aws.String(name),
+			Type:            aws.String(recordType),
+			TTL:             aws.Int64(300),
+			ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(value)}},
+		}
+		if identifier != "" {
+			rs.SetIdentifier = aws.String(identifier)
+			rs.Weight = aws.Int64(1)
+		}
+		return rs
+	}
+	registry := func(owner, resource string) string {
+		return fmt.Sprintf("\"heritage=external-dns,external-dns/owner=%s,external-dns/resource=%s\"", owner, resource)
+	}
+	tests := []struct {
+		name       string
+		adopt      string
+		identifier string
+		recordSets []*route53.ResourceRecordSet
+		want       []string
+		wantErr    bool
+	}{
+		{
+			name:       "weighted",
+			adopt:      "ext",
+			identifier: "shared",
+			recordSets: []*route53.ResourceRecordSet{
+				record("adopt.test.takutakahashi.dev", "A", "shared", "10.10.10.9"),
+				record("adopt.test.takutakahashi.dev", "TXT", "shared", registry("ext", "service/test/test")),
+			},
+			want: []string{
+				"adopt.test.takutakahashi.dev. A shared",
+				"extr53-adopt.test.takutakahashi.dev. TXT shared",
+			},
+		},
+		{
+			name:  "simple",
+			adopt: "ext",
+			recordSets: []*route53.ResourceRecordSet{
+				record("adopt.test.takutakahashi.dev", "A", "", "10.10.10.9"),
+				record("a-adopt.test.takutakahashi.dev", "TXT", "", registry("ext", "service/test/test")),
+			},
+			want: []string{
+				"adopt.test.takutakahashi.dev. A test/test/aaa",
+				"extr53-adopt.test.takutakahashi.dev. TXT test/test/aaa",
+			},
+		},
+		{
+			name:       "other-owner",
+			adopt:      "ext",
+			identifier: "shared",
+			recordSets: []*route53.ResourceRecordSet{
+				record("adopt.test.takutakahashi.dev", "A", "shared", "10.10.10.9"),
+				record("adopt.test.takutakahashi.dev", "TXT", "shared", registry("other", "service/test/test")),
+			},
+			wantErr: true,
+		},
+		{
+			name:       "other-resource",
+			adopt:      "ext",
+			identifier: "shared",
+			recordSets: []*route53.ResourceRecordSet{
+				record("adopt.test.takutakahashi.dev", "A", "shared", "10.10.10.9"),
+				record("adopt.test.takutakahashi.dev", "TXT", "shared", registry("ext", "service/test/other")),
+			},
+			wantErr: true,
+		},
+		{
+			name:       "disabled",
+			identifier: "shared",
+			recordSets: []*route53.ResourceRecordSet{
+				record("adopt.test.takutakahashi.dev", "A", "shared", "10.10.10.9"),
+				record("adopt.test.takutakahashi.dev", "TXT", "shared", registry("ext", "service/test/test")),
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			AdoptExternalDNSOwnerID = tt.adopt
+			api := newTestAPI()
+			changes := []*route53.Change{}
+			for _, rs := range tt.recordSets {
+				changes = append(changes, &route53.Change{Action: aws.String("CREATE"), ResourceRecordSet: rs})
+			}
+			if _, err := api.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+				HostedZoneId: aws.String("Z09261522C0IVI11TUTK7"),
+				ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			svc := &corev1.Service{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "test",
+					Namespace: "test",
+					Annotations: map[string]string{
+						HostnameAnnotationKey: "adopt.test.takutakahashi.dev",
+						zoneAnnotationKey:     "Z09261522C0IVI11TUTK7",
+					},
+					UID: "aaa",
+				},
+				Spec: corev1.ServiceSpec{
+					Type:        corev1.ServiceTypeLoadBalancer,
+					ExternalIPs: []string{"10.10.10.1"},
+				},
+			}
+			if tt.identifier != "" {
+				svc.Annotations[setIdentifierAnnotationKey] = tt.identifier
+			}
+			err := Ensure(api, svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				if got := api.RecordSets("Z09261522C0IVI11TUTK7"); len(got) != len(tt.recordSets) {
+					t.Errorf("Ensure() changed the records of external-dns: %v", got)
+				}
+				return
+			}
+			got := []string{}
+			for _, rs := range api.RecordSets("Z09261522C0IVI11TUTK7") {
+				got = append(got, fmt.Sprintf("%s %s %s", *rs.Name, *rs.Type, aws.StringValue(rs.SetIdentifier)))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("record sets = %v, want %v", got, tt.want)
 			}
 		})
 	}
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// DrainUntilAnnotationKey is the time until which the drained records of a service are kept before the deletion.
+const DrainUntilAnnotationKey = "external-route53.io/drain-until"
+
+// Drain withdraws the records applied for the service from the answers before they are deleted.
+// Weighted records get weight 0 and failover records are deleted, the others are kept until the deletion.
+// Weighted records without another member of a weight over 0 in their set are deleted as well.
+// It returns the longest TTL of the records, resolvers may keep answering them for that long.
+func Drain(api r53api.API, svc *corev1.Service) (time.Duration, error) {
+	ros, err := lastApplied(svc)
+	if err != nil {
+		return 0, err
+	}
+	drained := []UpsertRecordSetOpt{}
+	changes := newZoneChanges()
+	var ttl time.Duration
+	for _, ro := range ros {
+		if d := time.Duration(ro.TTL) * time.Second; d > ttl {
+			ttl = d
+		}
+		switch routingPolicy(ro) {
+		case "weighted":
+			shared, err := sharesWeight(api, ro)
+			if err != nil {
+				return 0, err
+			}
+			if shared {
+				if ro.Weight != 0 {
+					ro.Weight = 0
+					if err := validateRecordSetOpt(api, ro); err != nil {
+						return 0, err
+					}
+					cs, err := upsertChanges(api, ro)
+					if err != nil {
+						return 0, err
+					}
+					changes.add(ro.HostedZoneID, cs)
+				}
+				break
+			}
+			// Route53 answers the records of a weighted set evenly when every weight is 0, weight 0 drains nothing
+			fallthrough
+		case "failover":
+			cs, err := deleteChanges(api, ro)
+			if err != nil {
+				return 0, err
+			}
+			changes.add(ro.HostedZoneID, cs)
+			continue
+		}
+		drained = append(drained, ro)
+	}
+	// the records are drained all at once or left as they are for the next try
+	if _, err := changes.submit(api); err != nil {
+		return 0, err
+	}
+	// the records are deleted later with the values they have now
+	return ttl, setLastApplied(svc, drained)
+}
+
+// sharesWeight reports whether another record set in the weighted set of ro has a weight over 0 to take over its answers.
+func sharesWeight(api r53api.API, ro UpsertRecordSetOpt) (bool, error) {
+	recordSets, err := listRecordSets(api, ro.HostedZoneID)
+	if err != nil {
+		return false, err
+	}
+	for key, rs := range recordSets {
+		if key.name == normalizeDomain(ro.Hostname) && key.recordType == ro.Type && key.identifier != ro.Identifier &&
+			aws.Int64Value(rs.Weight) > 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// Drift is a record set applied by the controller and changed or deleted outside of it.
+type Drift struct {
+	Name       string
+	Type       string
+	Identifier string
+	// Reason is "missing" or "changed"
+	Reason string
+}
+
+func (d Drift) String() string {
+	return fmt.Sprintf("%s %s %s (%s)", d.Name, d.Type, d.Identifier, d.Reason)
+}
+
+// DetectDrift compares the records applied for the service with the live record sets.
+// Ensure restores them.
+func DetectDrift(api r53api.API, svc *corev1.Service) ([]Drift, error) {
+	ros, err := lastApplied(svc)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Drift{}
+	for _, ro := range ros {
+		for _, want := range recordSets(ro) {
+			live, err := liveRecordSet(api, ro.HostedZoneID, want)
+			if err != nil {
+				return nil, err
+			}
+			d := Drift{
+				Name:       aws.StringValue(want.Name),
+				Type:       aws.StringValue(want.Type),
+				Identifier: aws.StringValue(want.SetIdentifier),
+			}
+			switch {
+			case live == nil:
+				d.Reason = "missing"
+			case !recordSetEqual(want, live):
+				d.Reason = "changed"
+			default:
+				continue
+			}
+			ret = append(ret, d)
+		}
+	}
+	return ret, nil
+}
+
+// recordSetsChanged reports whether the record or its TXT record is missing or differs from ro.
+// They are compared with one listing of the hosted zone, shared by the reconciles until RecordCacheRefreshInterval passes,
+// so that skipping an UPSERT doesn't cost more requests than submitting it.
+func recordSetsChanged(api r53api.API, ro UpsertRecordSetOpt) (bool, error) {
+	live, err := listRecordSets(api, ro.HostedZoneID)
+	if err != nil {
+		return false, err
+	}
+	for _, want := range recordSets(ro) {
+		rs := live[recordSetKey(want)]
+		if rs == nil || !recordSetEqual(want, rs) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// restoreTxtRecords restores the TXT records of the applied records deleted outside of the controller,
+// otherwise the records fail the ownership check.
+func restoreTxtRecords(api r53api.API, ros []UpsertRecordSetOpt) error {
+	changes := newZoneChanges()
+	for _, ro := range ros {
+		rss := recordSets(ro)
+		rs, txt := rss[0], rss[1]
+		live, err := liveRecordSet(api, ro.HostedZoneID, rs)
+		if err != nil {
+			return err
+		}
+		liveTxt, err := liveRecordSet(api, ro.HostedZoneID, txt)
+		if err != nil {
+			return err
+		}
+		if live == nil || liveTxt != nil {
+			continue
+		}
+		changes.add(ro.HostedZoneID, []*route53.Change{{Action: aws.String("UPSERT"), ResourceRecordSet: txt}})
+	}
+	_, err := changes.submit(api)
+	return err
+}
+
+// liveRecordSet returns the record set with the name, type and identifier of rs, nil if it doesn't exist.
+func liveRecordSet(api r53api.API, hostedZoneID string, rs *route53.ResourceRecordSet) (*route53.ResourceRecordSet, error) {
+	return lookupRecordSet(api, hostedZoneID, aws.StringValue(rs.Name), aws.StringValue(rs.Type), aws.StringValue(rs.SetIdentifier))
+}
+
+// recordSetEqual reports whether both record sets have the same values
+// regardless of the trailing dots, the case of names and the order of the values.
+func recordSetEqual(a, b *route53.ResourceRecordSet) bool {
+	return reflect.DeepEqual(normalizeRecordSet(a), normalizeRecordSet(b))
+}
+
+func normalizeRecordSet(rs *route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	ret := awsutil.CopyOf(rs).(*route53.ResourceRecordSet)
+	ret.Name = aws.String(normalizeDomain(aws.StringValue(ret.Name)))
+	if ret.AliasTarget != nil {
+		ret.AliasTarget.DNSName = aws.String(normalizeDomain(aws.StringValue(ret.AliasTarget.DNSName)))
+		ret.AliasTarget.HostedZoneId = aws.String(strings.TrimPrefix(aws.StringValue(ret.AliasTarget.HostedZoneId), "/hostedzone/"))
+	}
+	if len(ret.ResourceRecords) == 0 {
+		ret.ResourceRecords = nil
+	}
+	if ret.GeoProximityLocation != nil && ret.GeoProximityLocation.Bias == nil {
+		// the default bias may be omitted
+		ret.GeoProximityLocation.Bias = aws.Int64(0)
+	}
+	sort.Slice(ret.ResourceRecords, func(i, j int) bool {
+		return aws.StringValue(ret.ResourceRecords[i].Value) < aws.StringValue(ret.ResourceRecords[j].Value)
+	})
+	return ret
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+)
+
+// CollectLegacyRecords lets the garbage collection delete the records with the legacy TXT records, which don't record the cluster owning them.
+// Every cluster sharing the hosted zones takes them as its own, so it must be set only when a single cluster manages them.
+var CollectLegacyRecords = false
+
+// ManagedRecords are the record sets of a set identifier in a hosted zone owned by the controller:
+// the records with a TXT record and the TXT records.
+type ManagedRecords struct {
+	HostedZoneID string
+	Identifier   string
+	// Namespace, Name and UID are the service of the identifier
+	Namespace  string
+	Name       string
+	UID        string
+	RecordSets []*route53.ResourceRecordSet
+}
+
+// ListManagedRecords scans the hosted zones managed by the controller for the records owned by the controller in this cluster.
+// The service is decoded from the TXT record, or from the identifier namespace/name/uid for the legacy TXT records when CollectLegacyRecords is set.
+// The legacy records of identifiers set by the set-identifier annotation are not listed, as they can't be mapped back to a service.
+func ListManagedRecords(api r53api.API) ([]ManagedRecords, error) {
+	zoneIDs, err := managedHostedZoneIDs(api)
+	if err != nil {
+		return nil, err
+	}
+	ret := []ManagedRecords{}
+	for _, zoneID := range zoneIDs {
+		recordSets, err := listRecordSets(api, zoneID)
+		if err != nil {
+			return nil, err
+		}
+		byIdentifier := map[string]*ManagedRecords{}
+		identifiers := []string{}
+		for key, rs := range recordSets {
+			if key.recordType == "TXT" || !domainAllowed(key.name) {
+				continue
+			}
+			ro := UpsertRecordSetOpt{Hostname: key.name, Type: key.recordType, TXTPrefix: "extr53-"}
+			txt := recordSets[recordKey{name: txtName(ro), recordType: "TXT", identifier: key.identifier}]
+			if txt == nil {
+				continue
+			}
+			owner, ok := parseOwner(txt)
+			if !ok {
+				continue
+			}
+			if owner == (Owner{}) {
+				if !CollectLegacyRecords {
+					continue
+				}
+			} else if owner.ClusterID != OwnerID {
+				continue
+			}
+			m, ok := byIdentifier[key.identifier]
+			if !ok {
+				m = &ManagedRecords{HostedZoneID: zoneID, Identifier: key.identifier}
+				if owner.Name != "" {
+					if !strings.EqualFold(owner.Kind, "Service") {
+						continue
+					}
+					m.Namespace, m.Name, m.UID = owner.Namespace, owner.Name, owner.UID
+				} else if parts := strings.Split(key.identifier, "/"); len(parts) >= 3 {
+					m.Namespace, m.Name, m.UID = parts[0], parts[1], parts[2]
+				} else {
+					continue
+				}
+				byIdentifier[key.identifier] = m
+				identifiers = append(identifiers, key.identifier)
+			}
+			m.RecordSets = append(m.RecordSets, rs, txt)
+		}
+		sort.Strings(identifiers)
+		for _, id := range identifiers {
+			m := byIdentifier[id]
+			sort.Slice(m.RecordSets, func(i, j int) bool {
+				a, b := m.RecordSets[i], m.RecordSets[j]
+				if aws.StringValue(a.Name) != aws.StringValue(b.Name) {
+					return aws.StringValue(a.Name) < aws.StringValue(b.Name)
+				}
+				return aws.StringValue(a.Type) < aws.StringValue(b.Type)
+			})
+			ret = append(ret, *m)
+		}
+	}
+	return ret, nil
+}
+
+// managedHostedZoneIDs returns the hosted zone of HOSTED_ZONE_ID, or the hosted zones of the domains of DomainFilter.
+// The other hosted zones of the account may be shared with other controllers, so one of them must be set.
+func managedHostedZoneIDs(api r53api.API) ([]string, error) {
+	if id := os.Getenv("HOSTED_ZONE_ID"); id != "" {
+		return []string{strings.TrimPrefix(id, "/hostedzone/")}, nil
+	}
+	domains := filteredDomains()
+	if len(domains) == 0 {
+		return nil, fmt.Errorf("HOSTED_ZONE_ID or --domain-filter must be set to tell the hosted zones managed by the controller")
+	}
+	zones, err := listHostedZones(api)
+	if err != nil {
+		return nil, err
+	}
+	ret := []string{}
+	for _, z := range zones {
+		zname := normalizeDomain(aws.StringValue(z.Name))
+		for _, d := range domains {
+			// the zone of a domain, or a zone delegated under it
+			if inDomain(d, zname) || inDomain(zname, d) {
+				ret = append(ret, strings.TrimPrefix(aws.StringValue(z.Id), "/hostedzone/"))
+				break
+			}
+		}
+	}
+	return ret, nil
+}
+
+// DeleteManagedRecords deletes the records and their TXT records together.
+func DeleteManagedRecords(api r53api.API, m ManagedRecords) error {
+	changes := []*route53.Change{}
+	for _, rs := range m.RecordSets {
+		changes = append(changes, &route53.Change{
+			Action:            aws.String(route53.ChangeActionDelete),
+			ResourceRecordSet: rs,
+		})
+	}
+	_, err := submitChanges(api, m.HostedZoneID, changes)
+	return err
+}
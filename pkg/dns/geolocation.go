@@ -0,0 +1,145 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// defaultGeoLocation is the country code of the record answering locations no other geolocation record matches.
+const defaultGeoLocation = "*"
+
+// continentCodes are the continents supported by Route53 geolocation routing.
+var continentCodes = []string{"AF", "AN", "AS", "EU", "OC", "NA", "SA"}
+
+// countryCodes are the ISO 3166-1 alpha-2 country codes supported by Route53 geolocation routing.
+var countryCodes = strings.Fields(`
+	AD AE AF AG AI AL AM AO AQ AR AS AT AU AW AX AZ BA BB BD BE BF BG BH BI BJ BL BM BN BO BQ BR BS BT BV BW BY BZ
+	CA CC CD CF CG CH CI CK CL CM CN CO CR CU CV CW CX CY CZ DE DJ DK DM DO DZ EC EE EG EH ER ES ET FI FJ FK FM FO FR
+	GA GB GD GE GF GG GH GI GL GM GN GP GQ GR GS GT GU GW GY HK HM HN HR HT HU ID IE IL IM IN IO IQ IR IS IT JE JM JO JP
+	KE KG KH KI KM KN KP KR KW KY KZ LA LB LC LI LK LR LS LT LU LV LY MA MC MD ME MF MG MH MK ML MM MN MO MP MQ MR MS MT
+	MU MV MW MX MY MZ NA NC NE NF NG NI NL NO NP NR NU NZ OM PA PE PF PG PH PK PL PM PN PR PS PT PW PY QA RE RO RS RU RW
+	SA SB SC SD SE SG SH SI SJ SK SL SM SN SO SR SS ST SV SX SY SZ TC TD TF TG TH TJ TK TL TM TN TO TR TT TV TW TZ UA UG
+	UM US UY UZ VA VC VE VG VI VN VU WF WS YE YT ZA ZM ZW
+`)
+
+// subdivisionCodes are the subdivisions supported by Route53 geolocation routing, by country.
+// Route53 supports the subdivisions of no other country than the US.
+var subdivisionCodes = map[string][]string{
+	"US": strings.Fields(`
+		AK AL AR AZ CA CO CT DC DE FL GA HI IA ID IL IN KS KY LA MA MD ME MI MN MO MS MT NC ND NE NH NJ NM NV NY
+		OH OK OR PA RI SC SD TN TX UT VA VT WA WI WV WY
+	`),
+}
+
+// validateGeoLocation checks the codes of a geolocation record.
+// A record matches either a continent or a country, and a subdivision narrows down its country.
+func validateGeoLocation(continent, country, subdivision string) error {
+	if continent == "" && country == "" && subdivision == "" {
+		return nil
+	}
+	if continent != "" && country != "" {
+		return errors.New("geolocation continent and country can't be specified together")
+	}
+	if continent != "" && !containsString(continentCodes, continent) {
+		return fmt.Errorf("geolocation continent code is not supported: %s", continent)
+	}
+	if country != "" && country != defaultGeoLocation && !containsString(countryCodes, country) {
+		return fmt.Errorf("geolocation country code is not supported: %s", country)
+	}
+	if subdivision != "" {
+		codes, ok := subdivisionCodes[country]
+		if !ok {
+			return fmt.Errorf("geolocation subdivision codes are supported only in US: %s", country)
+		}
+		if !containsString(codes, subdivision) {
+			return fmt.Errorf("geolocation subdivision code is not supported in %s: %s", country, subdivision)
+		}
+	}
+	return nil
+}
+
+// maxGeoProximityBias is the largest bias of a geoproximity record, negative biases shrink its region.
+const maxGeoProximityBias = 99
+
+// parseGeoProximity reads the geoproximity annotations of the service into the GeoProximity* fields, validated.
+func parseGeoProximity(svc *corev1.Service) (UpsertRecordSetOpt, error) {
+	ro := UpsertRecordSetOpt{
+		GeoProximityRegion:         svc.Annotations[geoProximityRegionAnnotationKey],
+		GeoProximityLocalZoneGroup: svc.Annotations[geoProximityLocalZoneGroupAnnotationKey],
+	}
+	if s, ok := svc.Annotations[geoProximityCoordinatesAnnotationKey]; ok {
+		c := strings.Split(s, ",")
+		if len(c) != 2 {
+			return ro, fmt.Errorf("geoproximity coordinates must be latitude,longitude: %s", s)
+		}
+		ro.GeoProximityLatitude, ro.GeoProximityLongitude = strings.TrimSpace(c[0]), strings.TrimSpace(c[1])
+	}
+	if s, ok := svc.Annotations[geoProximityBiasAnnotationKey]; ok {
+		bias, err := strconv.Atoi(s)
+		if err != nil {
+			return ro, err
+		}
+		ro.GeoProximityBias = bias
+	}
+	if routingPolicy(ro) != "geoproximity" {
+		if ro.GeoProximityBias != 0 {
+			return ro, errors.New("geoproximity bias needs region, local zone group or coordinates")
+		}
+		return ro, nil
+	}
+	return ro, validateGeoProximity(ro)
+}
+
+// validateGeoProximity checks the location of a geoproximity record.
+// A record is located by exactly one of an AWS region, a local zone group or coordinates.
+func validateGeoProximity(ro UpsertRecordSetOpt) error {
+	locations := 0
+	for _, l := range []string{ro.GeoProximityRegion, ro.GeoProximityLocalZoneGroup, ro.GeoProximityLatitude + ro.GeoProximityLongitude} {
+		if l != "" {
+			locations++
+		}
+	}
+	if locations != 1 {
+		return errors.New("geoproximity needs exactly one of region, local zone group and coordinates")
+	}
+	if ro.GeoProximityRegion != "" && !supportedRegion(ro.GeoProximityRegion) {
+		return fmt.Errorf("geoproximity region is not supported: %s", ro.GeoProximityRegion)
+	}
+	if ro.GeoProximityLatitude != "" || ro.GeoProximityLongitude != "" {
+		if err := validateCoordinate(ro.GeoProximityLatitude, 90); err != nil {
+			return fmt.Errorf("geoproximity latitude is invalid: %v", err)
+		}
+		if err := validateCoordinate(ro.GeoProximityLongitude, 180); err != nil {
+			return fmt.Errorf("geoproximity longitude is invalid: %v", err)
+		}
+	}
+	if ro.GeoProximityBias < -maxGeoProximityBias || ro.GeoProximityBias > maxGeoProximityBias {
+		return fmt.Errorf("geoproximity bias must be between -%d and %d: %d", maxGeoProximityBias, maxGeoProximityBias, ro.GeoProximityBias)
+	}
+	return nil
+}
+
+// validateCoordinate checks a latitude or a longitude in degrees.
+func validateCoordinate(s string, max float64) error {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return err
+	}
+	if f < -max || f > max {
+		return fmt.Errorf("%s is out of -%v to %v", s, max, max)
+	}
+	return nil
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"encoding/json"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func lastApplied(svc *corev1.Service) ([]UpsertRecordSetOpt, error) {
+	ros := []UpsertRecordSetOpt{}
+	s, ok := svc.Annotations[LastAppliedAnnotationKey]
+	if !ok {
+		return ros, nil
+	}
+	if err := json.Unmarshal([]byte(s), &ros); err != nil {
+		return nil, err
+	}
+	return ros, nil
+}
+
+func setLastApplied(svc *corev1.Service, ros []UpsertRecordSetOpt) error {
+	b, err := json.Marshal(ros)
+	if err != nil {
+		return err
+	}
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[LastAppliedAnnotationKey] = string(b)
+	return nil
+}
+
+func clearLastApplied(svc *corev1.Service) {
+	delete(svc.Annotations, LastAppliedAnnotationKey)
+}
+
+// sameRecordSet reports whether both options address the same record set in Route53,
+// so that an UPSERT of one replaces the other. Route53 can't change the routing policy of a record set in place.
+func sameRecordSet(a, b UpsertRecordSetOpt) bool {
+	return a.HostedZoneID == b.HostedZoneID &&
+		domainEqual(a.Hostname, b.Hostname) &&
+		a.Type == b.Type &&
+		a.Identifier == b.Identifier &&
+		routingPolicy(a) == routingPolicy(b) &&
+		a.TXTPrefix == b.TXTPrefix
+}
+
+func containsRecordSet(ros []UpsertRecordSetOpt, ro UpsertRecordSetOpt) bool {
+	for _, r := range ros {
+		if sameRecordSet(r, ro) {
+			return true
+		}
+	}
+	return false
+}
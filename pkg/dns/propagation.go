@@ -0,0 +1,121 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The propagation of the last changes to the records of a service to the Route53 name servers.
+const (
+	// ChangeIDsAnnotationKey is the IDs of the changes, separated by commas
+	ChangeIDsAnnotationKey = "external-route53.io/change-ids"
+	// ChangeStatusAnnotationKey is PENDING until every change is INSYNC
+	ChangeStatusAnnotationKey      = "external-route53.io/change-status"
+	ChangeSubmittedAtAnnotationKey = "external-route53.io/change-submitted-at"
+	ChangeInSyncAtAnnotationKey    = "external-route53.io/change-insync-at"
+)
+
+// PropagationPollInterval is how often the pending changes are polled until they are INSYNC.
+var PropagationPollInterval = 10 * time.Second
+
+var (
+	pendingSeenMu sync.Mutex
+	// pendingSeen is when each change was last polled PENDING
+	pendingSeen = map[string]time.Time{}
+)
+
+// CheckPropagation polls the pending changes of the service once and reports whether every change is INSYNC.
+// The status of the changes in the annotations of svc is updated when they are.
+// The propagation time of a change is observed only when it was polled PENDING within the last poll intervals,
+// otherwise it would include the delay of the poll, e.g. while the controller was restarting.
+func CheckPropagation(api r53api.API, svc *corev1.Service) (bool, error) {
+	if svc.Annotations[ChangeStatusAnnotationKey] != route53.ChangeStatusPending {
+		return true, nil
+	}
+	now := time.Now()
+	latencies := []time.Duration{}
+	synced := []string{}
+	for _, id := range strings.Split(svc.Annotations[ChangeIDsAnnotationKey], ",") {
+		if id == "" {
+			continue
+		}
+		out, err := api.GetChange(&route53.GetChangeInput{Id: aws.String(id)})
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == route53.ErrCodeNoSuchChange {
+			// the change is too old to be tracked
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		if aws.StringValue(out.ChangeInfo.Status) != route53.ChangeStatusInsync {
+			setPendingSeen(id, now)
+			return false, nil
+		}
+		synced = append(synced, id)
+		if seen, ok := lastPendingSeen(id); ok && now.Sub(seen) <= 2*PropagationPollInterval {
+			latencies = append(latencies, now.Sub(aws.TimeValue(out.ChangeInfo.SubmittedAt)))
+		}
+	}
+	for _, l := range latencies {
+		metrics.ChangePropagationDuration.Observe(l.Seconds())
+	}
+	forgetPendingSeen(synced)
+	svc.Annotations[ChangeStatusAnnotationKey] = route53.ChangeStatusInsync
+	svc.Annotations[ChangeInSyncAtAnnotationKey] = now.Format(time.RFC3339)
+	return true, nil
+}
+
+// setPendingSeen records that the change was polled PENDING at now.
+// The changes not polled for a while, e.g. of deleted services, are dropped.
+func setPendingSeen(id string, now time.Time) {
+	pendingSeenMu.Lock()
+	defer pendingSeenMu.Unlock()
+	for i, seen := range pendingSeen {
+		if now.Sub(seen) > 2*PropagationPollInterval {
+			delete(pendingSeen, i)
+		}
+	}
+	pendingSeen[id] = now
+}
+
+func lastPendingSeen(id string) (time.Time, bool) {
+	pendingSeenMu.Lock()
+	defer pendingSeenMu.Unlock()
+	seen, ok := pendingSeen[id]
+	return seen, ok
+}
+
+func forgetPendingSeen(ids []string) {
+	pendingSeenMu.Lock()
+	defer pendingSeenMu.Unlock()
+	for _, id := range ids {
+		delete(pendingSeen, id)
+	}
+}
+
+// setChangesPending records the changes submitted for the service to track their propagation.
+func setChangesPending(svc *corev1.Service, changeIDs []string, now time.Time) {
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[ChangeIDsAnnotationKey] = strings.Join(changeIDs, ",")
+	svc.Annotations[ChangeStatusAnnotationKey] = route53.ChangeStatusPending
+	svc.Annotations[ChangeSubmittedAtAnnotationKey] = now.Format(time.RFC3339)
+	delete(svc.Annotations, ChangeInSyncAtAnnotationKey)
+}
+
+// appendChangeID appends id unless it's empty or already appended, the changes of a service may share a change batch.
+func appendChangeID(ids []string, id string) []string {
+	if id == "" || containsString(ids, id) {
+		return ids
+	}
+	return append(ids, id)
+}
@@ -0,0 +1,136 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+)
+
+// RecordCacheRefreshInterval is how long the listed record sets of a hosted zone are used before listing them again.
+// The cached record sets are updated with the changes the controller submits.
+var RecordCacheRefreshInterval = time.Minute
+
+type recordKey struct {
+	name       string
+	recordType string
+	identifier string
+}
+
+// cachedRecordSets is the record sets of a hosted zone. recordSets is replaced instead of modified,
+// so that the callers can read the map they got without the lock.
+type cachedRecordSets struct {
+	mu         sync.Mutex
+	recordSets map[recordKey]*route53.ResourceRecordSet
+	fetchedAt  time.Time
+}
+
+var (
+	// recordSetsMu guards zoneRecords, the record sets of each hosted zone are guarded by their own lock
+	recordSetsMu sync.Mutex
+	zoneRecords  = map[r53api.API]map[string]*cachedRecordSets{}
+)
+
+// lookupRecordSet returns the record set with the name, type and identifier in the hosted zone, nil if it doesn't exist.
+func lookupRecordSet(api r53api.API, hostedZoneID, name, recordType, identifier string) (*route53.ResourceRecordSet, error) {
+	recordSets, err := listRecordSets(api, hostedZoneID)
+	if err != nil {
+		return nil, err
+	}
+	return recordSets[recordKey{name: normalizeDomain(name), recordType: recordType, identifier: identifier}], nil
+}
+
+func recordSetKey(rs *route53.ResourceRecordSet) recordKey {
+	return recordKey{
+		name:       normalizeDomain(aws.StringValue(rs.Name)),
+		recordType: aws.StringValue(rs.Type),
+		identifier: aws.StringValue(rs.SetIdentifier),
+	}
+}
+
+// zoneRecordSets returns the cache of the record sets of the hosted zone.
+func zoneRecordSets(api r53api.API, hostedZoneID string) *cachedRecordSets {
+	hostedZoneID = strings.TrimPrefix(hostedZoneID, "/hostedzone/")
+	recordSetsMu.Lock()
+	defer recordSetsMu.Unlock()
+	if zoneRecords[api] == nil {
+		zoneRecords[api] = map[string]*cachedRecordSets{}
+	}
+	c, ok := zoneRecords[api][hostedZoneID]
+	if !ok {
+		c = &cachedRecordSets{}
+		zoneRecords[api][hostedZoneID] = c
+	}
+	return c
+}
+
+// listRecordSets returns every record set of the hosted zone, cached for RecordCacheRefreshInterval.
+// The returned map must not be modified.
+func listRecordSets(api r53api.API, hostedZoneID string) (map[recordKey]*route53.ResourceRecordSet, error) {
+	c := zoneRecordSets(api, hostedZoneID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recordSets != nil && time.Since(c.fetchedAt) < RecordCacheRefreshInterval {
+		metrics.RecordCacheRequests.WithLabelValues("hit").Inc()
+		return c.recordSets, nil
+	}
+	metrics.RecordCacheRequests.WithLabelValues("miss").Inc()
+	start := time.Now()
+	recordSets := map[recordKey]*route53.ResourceRecordSet{}
+	in := &route53.ListResourceRecordSetsInput{HostedZoneId: aws.String(strings.TrimPrefix(hostedZoneID, "/hostedzone/"))}
+	for {
+		out, err := api.ListResourceRecordSets(in)
+		if err != nil {
+			return nil, err
+		}
+		for _, rs := range out.ResourceRecordSets {
+			recordSets[recordSetKey(rs)] = rs
+		}
+		if !aws.BoolValue(out.IsTruncated) {
+			break
+		}
+		in.StartRecordName = out.NextRecordName
+		in.StartRecordType = out.NextRecordType
+		in.StartRecordIdentifier = out.NextRecordIdentifier
+	}
+	metrics.RecordCacheRefreshDuration.Observe(time.Since(start).Seconds())
+	c.recordSets = recordSets
+	c.fetchedAt = time.Now()
+	return recordSets, nil
+}
+
+// applyChanges updates the cached record sets of the hosted zone with the changes Route53 accepted.
+func applyChanges(api r53api.API, hostedZoneID string, changes []*route53.Change) {
+	c := zoneRecordSets(api, hostedZoneID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.recordSets == nil {
+		return
+	}
+	recordSets := make(map[recordKey]*route53.ResourceRecordSet, len(c.recordSets))
+	for k, rs := range c.recordSets {
+		recordSets[k] = rs
+	}
+	for _, change := range changes {
+		key := recordSetKey(change.ResourceRecordSet)
+		if aws.StringValue(change.Action) == route53.ChangeActionDelete {
+			delete(recordSets, key)
+			continue
+		}
+		recordSets[key] = awsutil.CopyOf(change.ResourceRecordSet).(*route53.ResourceRecordSet)
+	}
+	c.recordSets = recordSets
+}
+
+// invalidateRecordSets makes the next lookup list the record sets of the hosted zone again.
+func invalidateRecordSets(api r53api.API, hostedZoneID string) {
+	c := zoneRecordSets(api, hostedZoneID)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.recordSets = nil
+}
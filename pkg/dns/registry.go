@@ -0,0 +1,127 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// OwnerID identifies the cluster owning the records, so that clusters sharing a hosted zone leave the records of the others alone.
+var OwnerID = "default"
+
+const (
+	heritage = "external-route53"
+	// legacyTxtRecordValue is the value of the TXT records set before the owner was recorded.
+	// The records are owned by whichever cluster updates them first.
+	legacyTxtRecordValue = "\"set by external-route53\""
+)
+
+// Owner is the cluster and the resource owning a record, encoded in the value of its TXT record.
+type Owner struct {
+	ClusterID string
+	Kind      string
+	Namespace string
+	Name      string
+	UID       string
+}
+
+// txtRecordValue returns the value of the TXT record managing the record.
+func txtRecordValue(ro UpsertRecordSetOpt) string {
+	if ro.Owner == (Owner{}) {
+		return legacyTxtRecordValue
+	}
+	return ro.Owner.encode()
+}
+
+// encode encodes the owner into the value of a TXT record.
+func (o Owner) encode() string {
+	return quoteTxtValue(fmt.Sprintf("heritage=%s,%s/owner=%s,%s/resource=%s/%s/%s,%s/uid=%s",
+		heritage, heritage, o.ClusterID, heritage, strings.ToLower(o.Kind), o.Namespace, o.Name, heritage, o.UID))
+}
+
+// maxTxtStringLength is the limit of Route53 on the length of each character string in the value of a TXT record.
+const maxTxtStringLength = 255
+
+// quoteTxtValue quotes s into the value of a TXT record, split into character strings within maxTxtStringLength.
+// Long namespaces, names and cluster IDs take more than one character string.
+func quoteTxtValue(s string) string {
+	strs := []string{}
+	for len(s) > maxTxtStringLength {
+		strs = append(strs, "\""+s[:maxTxtStringLength]+"\"")
+		s = s[maxTxtStringLength:]
+	}
+	strs = append(strs, "\""+s+"\"")
+	return strings.Join(strs, " ")
+}
+
+// unquoteTxtValue joins the character strings of the value of a TXT record.
+func unquoteTxtValue(value string) string {
+	if !strings.Contains(value, "\"") {
+		return value
+	}
+	var b strings.Builder
+	quoted := false
+	for _, c := range value {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// parseOwner decodes the owner of the TXT record. ok is false for TXT records not set by external-route53.
+// The TXT records set before the owner was recorded have an empty owner.
+func parseOwner(txt *route53.ResourceRecordSet) (owner Owner, ok bool) {
+	for _, rr := range txt.ResourceRecords {
+		value := aws.StringValue(rr.Value)
+		if value == legacyTxtRecordValue {
+			return Owner{}, true
+		}
+		labels := parseLabels(value)
+		if labels["heritage"] != heritage {
+			continue
+		}
+		owner = Owner{ClusterID: labels[heritage+"/owner"], UID: labels[heritage+"/uid"]}
+		resource := strings.SplitN(labels[heritage+"/resource"], "/", 3)
+		if len(resource) == 3 {
+			owner.Kind, owner.Namespace, owner.Name = resource[0], resource[1], resource[2]
+		}
+		return owner, true
+	}
+	return Owner{}, false
+}
+
+// parseLabels decodes the value of a TXT record of the registry, "heritage=...,key=value,...".
+func parseLabels(value string) map[string]string {
+	labels := map[string]string{}
+	for _, l := range strings.Split(unquoteTxtValue(value), ",") {
+		kv := strings.SplitN(l, "=", 2)
+		if len(kv) == 2 {
+			labels[kv[0]] = kv[1]
+		}
+	}
+	return labels
+}
+
+// validateOwner checks that the records of ro may be changed by the resource of ro in this cluster.
+// Two cases are allowed on purpose:
+//   - The UID isn't compared, a resource recreated with the same name takes over its records.
+//   - The records with the legacy TXT record, set before the owner was recorded, are taken over by the first resource
+//     of any cluster changing them, and get its owner. Clusters sharing a hosted zone must not both publish such a hostname.
+func validateOwner(owner Owner, ro UpsertRecordSetOpt) error {
+	if owner == (Owner{}) {
+		return nil
+	}
+	if owner.ClusterID != OwnerID {
+		return fmt.Errorf("%s %s is owned by the cluster %q", ro.Hostname, ro.Type, owner.ClusterID)
+	}
+	if ro.Owner.Name != "" && (!strings.EqualFold(owner.Kind, ro.Owner.Kind) || owner.Namespace != ro.Owner.Namespace || owner.Name != ro.Owner.Name) {
+		return fmt.Errorf("%s %s is owned by %s %s/%s", ro.Hostname, ro.Type, owner.Kind, owner.Namespace, owner.Name)
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+)
+
+// PreferredZoneType is the type of the hosted zone chosen when a public and a private zone have the same name, public or private.
+var PreferredZoneType = "public"
+
+// DomainFilter is the domains the hostnames must be in, separated by commas. Every hostname is allowed when it's empty.
+var DomainFilter = ""
+
+// hostedZonesTTL is how long the listed hosted zones are used before listing them again.
+const hostedZonesTTL = 5 * time.Minute
+
+type cachedHostedZones struct {
+	zones     []*route53.HostedZone
+	fetchedAt time.Time
+}
+
+var (
+	hostedZonesMu sync.Mutex
+	hostedZones   = map[r53api.API]*cachedHostedZones{}
+)
+
+// listHostedZones returns every hosted zone of the account, cached for hostedZonesTTL.
+func listHostedZones(api r53api.API) ([]*route53.HostedZone, error) {
+	hostedZonesMu.Lock()
+	defer hostedZonesMu.Unlock()
+	if c, ok := hostedZones[api]; ok && time.Since(c.fetchedAt) < hostedZonesTTL {
+		return c.zones, nil
+	}
+	zones := []*route53.HostedZone{}
+	in := &route53.ListHostedZonesInput{}
+	for {
+		out, err := api.ListHostedZones(in)
+		if err != nil {
+			return nil, err
+		}
+		zones = append(zones, out.HostedZones...)
+		if !aws.BoolValue(out.IsTruncated) {
+			break
+		}
+		in.Marker = out.NextMarker
+	}
+	hostedZones[api] = &cachedHostedZones{zones: zones, fetchedAt: time.Now()}
+	return zones, nil
+}
+
+// findHostedZoneID returns the ID of the hosted zone whose name is the longest suffix of hostname.
+// When a public and a private zone have the same name, the zone of PreferredZoneType wins.
+func findHostedZoneID(api r53api.API, hostname string) (string, error) {
+	zones, err := listHostedZones(api)
+	if err != nil {
+		return "", err
+	}
+	preferPrivate := strings.ToLower(PreferredZoneType) == "private"
+	name := normalizeDomain(hostname)
+	var ret *route53.HostedZone
+	for _, z := range zones {
+		zname := normalizeDomain(aws.StringValue(z.Name))
+		if !inDomain(name, zname) {
+			continue
+		}
+		if ret != nil {
+			rname := normalizeDomain(aws.StringValue(ret.Name))
+			if len(zname) < len(rname) {
+				continue
+			}
+			if len(zname) == len(rname) && isPrivateZone(ret) == preferPrivate {
+				continue
+			}
+		}
+		ret = z
+	}
+	if ret == nil {
+		return "", fmt.Errorf("hosted zone is not found for %s", hostname)
+	}
+	return strings.TrimPrefix(aws.StringValue(ret.Id), "/hostedzone/"), nil
+}
+
+// hostedZoneName returns the name of the hosted zone from the listed hosted zones.
+// A hosted zone created after they were listed is looked up on its own.
+func hostedZoneName(api r53api.API, hostedZoneID string) (string, error) {
+	zones, err := listHostedZones(api)
+	if err != nil {
+		return "", err
+	}
+	id := strings.TrimPrefix(hostedZoneID, "/hostedzone/")
+	for _, z := range zones {
+		if strings.TrimPrefix(aws.StringValue(z.Id), "/hostedzone/") == id {
+			return aws.StringValue(z.Name), nil
+		}
+	}
+	out, err := api.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String(hostedZoneID)})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.HostedZone.Name), nil
+}
+
+// domainAllowed reports whether hostname is in one of the domains of DomainFilter.
+// Every hostname is allowed when DomainFilter is empty.
+func domainAllowed(hostname string) bool {
+	domains := filteredDomains()
+	if len(domains) == 0 {
+		return true
+	}
+	for _, d := range domains {
+		if inDomain(normalizeDomain(hostname), d) {
+			return true
+		}
+	}
+	return false
+}
+
+// filteredDomains returns the domains of DomainFilter.
+func filteredDomains() []string {
+	ret := []string{}
+	for _, d := range strings.Split(DomainFilter, ",") {
+		if d = normalizeDomain(d); d != "" {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+func isPrivateZone(z *route53.HostedZone) bool {
+	return z.Config != nil && aws.BoolValue(z.Config.PrivateZone)
+}
+
+// inDomain reports whether name is domain or one of its subdomains.
+func inDomain(name, domain string) bool {
+	return name == domain || strings.HasSuffix(name, "."+domain)
+}
+
+// normalizeDomain returns the domain in lower case without the trailing dot and the escapes of Route53,
+// so that the names given by the services and the names returned by Route53 compare equal.
+func normalizeDomain(s string) string {
+	return strings.TrimSuffix(strings.ToLower(unescapeDomain(strings.TrimSpace(s))), ".")
+}
+
+// unescapeDomain replaces the \ooo octal escapes of the domain with their characters.
+// Route53 returns the characters other than a-z, 0-9, - and _ escaped, e.g. the * of a wildcard as \052.
+func unescapeDomain(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
@@ -3,16 +3,17 @@ package healthcheck
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/route53"
 	"github.com/juju/errors"
 	"github.com/sirupsen/logrus"
 	route53v1 "github.com/takutakahashi/external-route53/api/v1"
 	r53client "github.com/takutakahashi/external-route53/pkg/client"
 	"github.com/takutakahashi/external-route53/pkg/dns"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -68,7 +69,8 @@ func buildResource(svc *corev1.Service) (*route53v1.HealthCheck, error) {
 	if len(svc.Spec.Ports) == 0 {
 		return nil, errors.New("no ports were found")
 	}
-	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+	endpoint, ok := loadBalancerEndpoint(svc)
+	if !ok {
 		return nil, errors.New("no loadbalancer IP was found")
 	}
 	p := svc.Spec.Ports[0].Port
@@ -81,13 +83,11 @@ func buildResource(svc *corev1.Service) (*route53v1.HealthCheck, error) {
 			Namespace: svc.Namespace,
 		},
 		Spec: route53v1.HealthCheckSpec{
-			Enabled:  true,
-			Invert:   false,
-			Protocol: route53v1.ProtocolTCP,
-			Port:     int(p),
-			Endpoint: route53v1.HealthCheckEndpoint{
-				Address: svc.Status.LoadBalancer.Ingress[0].IP,
-			},
+			Enabled:          true,
+			Invert:           false,
+			Protocol:         route53v1.ProtocolTCP,
+			Port:             int(p),
+			Endpoint:         endpoint,
 			FailureThreshold: 3,
 			Features: route53v1.HealthCheckFeatures{
 				FastInterval: true,
@@ -105,11 +105,20 @@ func buildResource(svc *corev1.Service) (*route53v1.HealthCheck, error) {
 	return &h, nil
 }
 
-func Ensure(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
+// loadBalancerEndpoint returns the first ingress of the load balancer with an IP or a hostname.
+// ok is false until the load balancer is provisioned.
+func loadBalancerEndpoint(svc *corev1.Service) (endpoint route53v1.HealthCheckEndpoint, ok bool) {
+	for _, ing := range svc.Status.LoadBalancer.Ingress {
+		if ing.IP != "" || ing.Hostname != "" {
+			return route53v1.HealthCheckEndpoint{Address: ing.IP, Hostname: ing.Hostname}, true
+		}
+	}
+	return route53v1.HealthCheckEndpoint{}, false
+}
+
+func Ensure(api r53api.API, h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 	name := fmt.Sprintf("%s/%s", h.Namespace, h.Name)
 	callerReference := fmt.Sprintf("%s/%s", name, h.ResourceVersion)
-	mySession := session.Must(session.NewSession())
-	r := route53.New(mySession)
 	var ip, hostname *string = nil, nil
 	if h.Spec.Endpoint.Address != "" {
 		ip = aws.String(h.Spec.Endpoint.Address)
@@ -143,7 +152,7 @@ func Ensure(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 		enableSNI = aws.Bool(true)
 	}
 	if id == "" {
-		out, err := r.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		out, err := api.CreateHealthCheck(&route53.CreateHealthCheckInput{
 			CallerReference: aws.String(callerReference),
 			HealthCheckConfig: &route53.HealthCheckConfig{
 				EnableSNI:                enableSNI,
@@ -165,7 +174,7 @@ func Ensure(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 		return h, nil
 	} else {
 
-		out, err := r.UpdateHealthCheck(&route53.UpdateHealthCheckInput{
+		out, err := api.UpdateHealthCheck(&route53.UpdateHealthCheckInput{
 			HealthCheckId:            aws.String(id),
 			EnableSNI:                enableSNI,
 			FailureThreshold:         aws.Int64(int64(h.Spec.FailureThreshold)),
@@ -181,7 +190,7 @@ func Ensure(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 		}
 		h.Status.ID = *out.HealthCheck.Id
 	}
-	out, err := r.ChangeTagsForResource(&route53.ChangeTagsForResourceInput{
+	out, err := api.ChangeTagsForResource(&route53.ChangeTagsForResourceInput{
 		ResourceType: aws.String("healthcheck"),
 		ResourceId:   aws.String(h.Status.ID),
 		AddTags: []*route53.Tag{
@@ -197,10 +206,8 @@ func Ensure(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 	return h, nil
 }
 
-func Delete(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
-	mySession := session.Must(session.NewSession())
-	r := route53.New(mySession)
-	_, err := r.DeleteHealthCheck(&route53.DeleteHealthCheckInput{
+func Delete(api r53api.API, h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
+	_, err := api.DeleteHealthCheck(&route53.DeleteHealthCheckInput{
 		HealthCheckId: aws.String(h.Status.ID),
 	})
 	if err != nil {
@@ -209,3 +216,20 @@ func Delete(h *route53v1.HealthCheck) (*route53v1.HealthCheck, error) {
 	h.Status.ID = ""
 	return h, nil
 }
+
+// Healthy reports whether most of the Route53 checkers observe the health check as healthy.
+func Healthy(api r53api.API, id string) (bool, error) {
+	out, err := api.GetHealthCheckStatus(&route53.GetHealthCheckStatusInput{
+		HealthCheckId: aws.String(id),
+	})
+	if err != nil {
+		return false, err
+	}
+	healthy := 0
+	for _, o := range out.HealthCheckObservations {
+		if o.StatusReport != nil && strings.HasPrefix(aws.StringValue(o.StatusReport.Status), "Success") {
+			healthy++
+		}
+	}
+	return healthy*2 > len(out.HealthCheckObservations), nil
+}
@@ -5,6 +5,8 @@ import (
 
 	"github.com/google/uuid"
 	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
+	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -49,13 +51,101 @@ func TestEnsureAndDelete(t *testing.T) {
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			h, err := Ensure(tt.args.h)
+			api := fake.New()
+			h, err := Ensure(api, tt.args.h)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
 			}
-			if _, err := Delete(h); (err != nil) != tt.wantErr {
+			if _, ok := api.HealthCheck(h.Status.ID); !ok {
+				t.Errorf("Ensure() health check %s was not created", h.Status.ID)
+			}
+			if _, err := Delete(api, h); (err != nil) != tt.wantErr {
 				t.Errorf("Ensure() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestHealthy(t *testing.T) {
+	api := fake.New()
+	h, err := Ensure(api, &route53v1.HealthCheck{
+		ObjectMeta: v1.ObjectMeta{
+			Name:      "test",
+			Namespace: "test",
+		},
+		Spec: route53v1.HealthCheckSpec{
+			Enabled:  true,
+			Protocol: route53v1.ProtocolTCP,
+			Port:     443,
+			Endpoint: route53v1.HealthCheckEndpoint{
+				Address: "8.8.8.8",
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []bool{true, false} {
+		api.SetHealthCheckStatus(h.Status.ID, want)
+		got, err := Healthy(api, h.Status.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Healthy() = %v, want %v", got, want)
+		}
+	}
+	if _, err := Healthy(api, "missing"); err == nil {
+		t.Errorf("Healthy() of a missing health check should fail")
+	}
+}
+
+func Test_buildResource(t *testing.T) {
+	tests := []struct {
+		name    string
+		ingress []corev1.LoadBalancerIngress
+		want    route53v1.HealthCheckEndpoint
+		wantErr bool
+	}{
+		{
+			name:    "ip",
+			ingress: []corev1.LoadBalancerIngress{{IP: "10.10.10.1"}},
+			want:    route53v1.HealthCheckEndpoint{Address: "10.10.10.1"},
+		},
+		{
+			name:    "hostname",
+			ingress: []corev1.LoadBalancerIngress{{}, {Hostname: "test.elb.amazonaws.com"}},
+			want:    route53v1.HealthCheckEndpoint{Hostname: "test.elb.amazonaws.com"},
+		},
+		{
+			name:    "not provisioned",
+			wantErr: true,
+		},
+		{
+			name:    "empty ingress",
+			ingress: []corev1.LoadBalancerIngress{{}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &corev1.Service{
+				ObjectMeta: v1.ObjectMeta{Name: "test", Namespace: "test"},
+				Spec: corev1.ServiceSpec{
+					Type:  corev1.ServiceTypeLoadBalancer,
+					Ports: []corev1.ServicePort{{Port: 443}},
+				},
+				Status: corev1.ServiceStatus{
+					LoadBalancer: corev1.LoadBalancerStatus{Ingress: tt.ingress},
+				},
+			}
+			h, err := buildResource(svc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("buildResource() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && h.Spec.Endpoint != tt.want {
+				t.Errorf("buildResource() endpoint = %v, want %v", h.Spec.Endpoint, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	// DriftCorrections counts the record sets changed outside of the controller and restored by it.
+	DriftCorrections = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "external_route53_drift_corrections_total",
+			Help: "Number of record sets restored after they were changed or deleted outside of the controller.",
+		},
+		[]string{"type", "reason"},
+	)
+	// RecordCacheRequests counts the lookups of the record sets of hosted zones by whether they were served from the cache.
+	RecordCacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "external_route53_record_cache_requests_total",
+			Help: "Number of lookups of the record sets of hosted zones, by result (hit or miss).",
+		},
+		[]string{"result"},
+	)
+	// RecordCacheRefreshDuration observes how long listing every record set of a hosted zone takes.
+	RecordCacheRefreshDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "external_route53_record_cache_refresh_duration_seconds",
+			Help:    "Time to list every record set of a hosted zone into the cache.",
+			Buckets: prometheus.DefBuckets,
+		},
+	)
+	// Route53ThrottledRequests counts the requests to Route53 failed with a retryable error, retried or not.
+	Route53ThrottledRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "external_route53_route53_throttled_requests_total",
+			Help: "Number of requests to Route53 throttled or failed by a change in progress, by operation and error code.",
+		},
+		[]string{"operation", "code"},
+	)
+	// ChangePropagationDuration observes how long the changes to the records take to reach every Route53 name server.
+	ChangePropagationDuration = prometheus.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "external_route53_change_propagation_duration_seconds",
+			Help:    "Time from the submission of a change to Route53 until it is observed INSYNC.",
+			Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+		},
+	)
+	// OrphanedRecords is the number of the record sets of services which no longer exist left after the last garbage collection.
+	OrphanedRecords = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "external_route53_orphaned_records",
+			Help: "Number of record sets owned by services which no longer exist, left after the last garbage collection.",
+		},
+	)
+	// OrphanedRecordsDeleted counts the record sets of services which no longer exist deleted by the garbage collection.
+	OrphanedRecordsDeleted = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Name: "external_route53_orphaned_records_deleted_total",
+			Help: "Number of record sets owned by services which no longer exist, deleted by the garbage collection.",
+		},
+	)
+)
+
+func init() {
+	metrics.Registry.MustRegister(DriftCorrections, RecordCacheRequests, RecordCacheRefreshDuration, Route53ThrottledRequests, ChangePropagationDuration, OrphanedRecords, OrphanedRecordsDeleted)
+}
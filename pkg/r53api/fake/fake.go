@@ -0,0 +1,728 @@
+package fake
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/awsutil"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/google/uuid"
+)
+
+const defaultMaxItems = 300
+
+// Route53 is an in-memory implementation of r53api.API.
+// It follows the Route53 rules the controller relies on: atomic change batches,
+// exact-match DELETE, set identifiers, list ordering and pagination, and health check CRUD.
+type Route53 struct {
+	mu           sync.Mutex
+	zones        map[string]*zone
+	healthChecks map[string]*route53.HealthCheck
+	unhealthy    map[string]bool
+	tags         map[string][]*route53.Tag
+	changes      map[string]*route53.ChangeInfo
+	changeSeq    int
+}
+
+type zone struct {
+	hostedZone *route53.HostedZone
+	records    []*route53.ResourceRecordSet
+}
+
+func New() *Route53 {
+	return &Route53{
+		zones:        map[string]*zone{},
+		healthChecks: map[string]*route53.HealthCheck{},
+		unhealthy:    map[string]bool{},
+		tags:         map[string][]*route53.Tag{},
+		changes:      map[string]*route53.ChangeInfo{},
+	}
+}
+
+// AddHostedZone registers an empty hosted zone with the given ID.
+func (f *Route53) AddHostedZone(id, name string, private bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id = trimZoneID(id)
+	f.zones[id] = &zone{
+		hostedZone: &route53.HostedZone{
+			Id:              aws.String("/hostedzone/" + id),
+			Name:            aws.String(normalizeName(name)),
+			CallerReference: aws.String(id),
+			Config: &route53.HostedZoneConfig{
+				PrivateZone: aws.Bool(private),
+			},
+		},
+	}
+}
+
+// RecordSets returns a copy of every record set in the zone, in list order.
+func (f *Route53) RecordSets(zoneID string) []*route53.ResourceRecordSet {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[trimZoneID(zoneID)]
+	if !ok {
+		return nil
+	}
+	ret := make([]*route53.ResourceRecordSet, 0, len(z.records))
+	for _, rs := range z.records {
+		ret = append(ret, copyRecordSet(rs))
+	}
+	return ret
+}
+
+// HealthCheck returns a copy of the health check with the given ID.
+func (f *Route53) HealthCheck(id string) (*route53.HealthCheck, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hc, ok := f.healthChecks[id]
+	if !ok {
+		return nil, false
+	}
+	return awsutil.CopyOf(hc).(*route53.HealthCheck), true
+}
+
+// SetHealthCheckStatus sets what the checkers of the health check observe. Health checks are healthy when created.
+func (f *Route53) SetHealthCheckStatus(id string, healthy bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.unhealthy[id] = !healthy
+}
+
+// Tags returns the tags attached to a resource.
+func (f *Route53) Tags(resourceType, id string) []*route53.Tag {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.tags[resourceType+"/"+id]
+}
+
+func (f *Route53) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[trimZoneID(aws.StringValue(in.HostedZoneId))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, fmt.Sprintf("No hosted zone found with ID: %s", aws.StringValue(in.HostedZoneId)), nil)
+	}
+	if in.ChangeBatch == nil || len(in.ChangeBatch.Changes) == 0 {
+		return nil, awserr.New(route53.ErrCodeInvalidInput, "Invalid request: Missing field 'Changes'", nil)
+	}
+	// the batch is applied to a copy so that a failing change leaves the zone untouched
+	records := append([]*route53.ResourceRecordSet{}, z.records...)
+	for _, c := range in.ChangeBatch.Changes {
+		if c.ResourceRecordSet == nil {
+			return nil, awserr.New(route53.ErrCodeInvalidInput, "Invalid request: Missing field 'ResourceRecordSet'", nil)
+		}
+		rs := copyRecordSet(c.ResourceRecordSet)
+		normalizeRecordSet(rs)
+		if err := validateRecordSet(z, rs); err != nil {
+			return nil, err
+		}
+		var err error
+		switch aws.StringValue(c.Action) {
+		case route53.ChangeActionCreate:
+			records, err = create(z, records, rs)
+		case route53.ChangeActionUpsert:
+			records, err = upsert(z, records, rs)
+		case route53.ChangeActionDelete:
+			records, err = del(records, rs)
+		default:
+			err = awserr.New(route53.ErrCodeInvalidInput, fmt.Sprintf("Invalid request: unknown action %s", aws.StringValue(c.Action)), nil)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortRecordSets(records)
+	z.records = records
+	f.changeSeq++
+	info := &route53.ChangeInfo{
+		Id:          aws.String(fmt.Sprintf("/change/C%013d", f.changeSeq)),
+		Status:      aws.String(route53.ChangeStatusPending),
+		SubmittedAt: aws.Time(time.Now()),
+		Comment:     in.ChangeBatch.Comment,
+	}
+	f.changes[aws.StringValue(info.Id)] = info
+	return &route53.ChangeResourceRecordSetsOutput{
+		ChangeInfo: awsutil.CopyOf(info).(*route53.ChangeInfo),
+	}, nil
+}
+
+// GetChange returns the status of a change. Changes stay PENDING until SyncChanges is called.
+func (f *Route53) GetChange(in *route53.GetChangeInput) (*route53.GetChangeOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := aws.StringValue(in.Id)
+	if !strings.HasPrefix(id, "/change/") {
+		id = "/change/" + id
+	}
+	info, ok := f.changes[id]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchChange, fmt.Sprintf("A change with the specified change ID does not exist: %s", aws.StringValue(in.Id)), nil)
+	}
+	return &route53.GetChangeOutput{ChangeInfo: awsutil.CopyOf(info).(*route53.ChangeInfo)}, nil
+}
+
+// SyncChanges marks every change INSYNC as if it reached all the Route53 name servers.
+func (f *Route53) SyncChanges() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, info := range f.changes {
+		info.Status = aws.String(route53.ChangeStatusInsync)
+	}
+}
+
+func (f *Route53) ListResourceRecordSets(in *route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[trimZoneID(aws.StringValue(in.HostedZoneId))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, fmt.Sprintf("No hosted zone found with ID: %s", aws.StringValue(in.HostedZoneId)), nil)
+	}
+	if in.StartRecordType != nil && in.StartRecordName == nil {
+		return nil, awserr.New(route53.ErrCodeInvalidInput, "The input is not valid: StartRecordType requires StartRecordName", nil)
+	}
+	if in.StartRecordIdentifier != nil && in.StartRecordType == nil {
+		return nil, awserr.New(route53.ErrCodeInvalidInput, "The input is not valid: StartRecordIdentifier requires StartRecordType", nil)
+	}
+	max := defaultMaxItems
+	if in.MaxItems != nil {
+		n, err := strconv.Atoi(*in.MaxItems)
+		if err != nil || n < 1 {
+			return nil, awserr.New(route53.ErrCodeInvalidInput, fmt.Sprintf("The input is not valid: MaxItems %s", *in.MaxItems), nil)
+		}
+		if n < max {
+			max = n
+		}
+	}
+	start := 0
+	if in.StartRecordName != nil {
+		from := recordKey{
+			name:       reverseName(normalizeName(*in.StartRecordName)),
+			recordType: aws.StringValue(in.StartRecordType),
+			identifier: aws.StringValue(in.StartRecordIdentifier),
+		}
+		start = sort.Search(len(z.records), func(i int) bool {
+			return !keyOf(z.records[i]).less(from)
+		})
+	}
+	end := start + max
+	if end > len(z.records) {
+		end = len(z.records)
+	}
+	out := &route53.ListResourceRecordSetsOutput{
+		IsTruncated:        aws.Bool(end < len(z.records)),
+		MaxItems:           aws.String(strconv.Itoa(max)),
+		ResourceRecordSets: []*route53.ResourceRecordSet{},
+	}
+	for _, rs := range z.records[start:end] {
+		out.ResourceRecordSets = append(out.ResourceRecordSets, copyRecordSet(rs))
+	}
+	if end < len(z.records) {
+		next := z.records[end]
+		out.NextRecordName = next.Name
+		out.NextRecordType = next.Type
+		out.NextRecordIdentifier = next.SetIdentifier
+	}
+	return out, nil
+}
+
+func (f *Route53) GetHostedZone(in *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	z, ok := f.zones[trimZoneID(aws.StringValue(in.Id))]
+	if !ok {
+		return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, fmt.Sprintf("No hosted zone found with ID: %s", aws.StringValue(in.Id)), nil)
+	}
+	hz := awsutil.CopyOf(z.hostedZone).(*route53.HostedZone)
+	hz.ResourceRecordSetCount = aws.Int64(int64(len(z.records)))
+	return &route53.GetHostedZoneOutput{HostedZone: hz}, nil
+}
+
+// ListHostedZones lists the zones in the order of their IDs, paginated by Marker.
+func (f *Route53) ListHostedZones(in *route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	max := defaultMaxItems
+	if in.MaxItems != nil {
+		n, err := strconv.Atoi(*in.MaxItems)
+		if err != nil || n < 1 {
+			return nil, awserr.New(route53.ErrCodeInvalidInput, fmt.Sprintf("The input is not valid: MaxItems %s", *in.MaxItems), nil)
+		}
+		if n < max {
+			max = n
+		}
+	}
+	ids := make([]string, 0, len(f.zones))
+	for id := range f.zones {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	start := 0
+	if in.Marker != nil {
+		start = sort.SearchStrings(ids, trimZoneID(*in.Marker))
+	}
+	end := start + max
+	if end > len(ids) {
+		end = len(ids)
+	}
+	out := &route53.ListHostedZonesOutput{
+		IsTruncated: aws.Bool(end < len(ids)),
+		Marker:      in.Marker,
+		MaxItems:    aws.String(strconv.Itoa(max)),
+		HostedZones: []*route53.HostedZone{},
+	}
+	for _, id := range ids[start:end] {
+		z := f.zones[id]
+		hz := awsutil.CopyOf(z.hostedZone).(*route53.HostedZone)
+		hz.ResourceRecordSetCount = aws.Int64(int64(len(z.records)))
+		out.HostedZones = append(out.HostedZones, hz)
+	}
+	if end < len(ids) {
+		out.NextMarker = aws.String(ids[end])
+	}
+	return out, nil
+}
+
+func (f *Route53) CreateHealthCheck(in *route53.CreateHealthCheckInput) (*route53.CreateHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if aws.StringValue(in.CallerReference) == "" || in.HealthCheckConfig == nil {
+		return nil, awserr.New(route53.ErrCodeInvalidInput, "Invalid request: CallerReference and HealthCheckConfig are required", nil)
+	}
+	for _, hc := range f.healthChecks {
+		if *hc.CallerReference != *in.CallerReference {
+			continue
+		}
+		// CreateHealthCheck is idempotent for the same caller reference and config
+		if reflect.DeepEqual(hc.HealthCheckConfig, in.HealthCheckConfig) {
+			return &route53.CreateHealthCheckOutput{
+				HealthCheck: awsutil.CopyOf(hc).(*route53.HealthCheck),
+				Location:    aws.String("https://route53.amazonaws.com/2013-04-01/healthcheck/" + *hc.Id),
+			}, nil
+		}
+		return nil, awserr.New(route53.ErrCodeHealthCheckAlreadyExists, fmt.Sprintf("A health check with caller reference %s already exists", *in.CallerReference), nil)
+	}
+	id := uuid.New().String()
+	hc := &route53.HealthCheck{
+		Id:                 aws.String(id),
+		CallerReference:    in.CallerReference,
+		HealthCheckConfig:  awsutil.CopyOf(in.HealthCheckConfig).(*route53.HealthCheckConfig),
+		HealthCheckVersion: aws.Int64(1),
+	}
+	f.healthChecks[id] = hc
+	return &route53.CreateHealthCheckOutput{
+		HealthCheck: awsutil.CopyOf(hc).(*route53.HealthCheck),
+		Location:    aws.String("https://route53.amazonaws.com/2013-04-01/healthcheck/" + id),
+	}, nil
+}
+
+func (f *Route53) UpdateHealthCheck(in *route53.UpdateHealthCheckInput) (*route53.UpdateHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	hc, ok := f.healthChecks[aws.StringValue(in.HealthCheckId)]
+	if !ok {
+		return nil, noSuchHealthCheck(aws.StringValue(in.HealthCheckId))
+	}
+	if in.HealthCheckVersion != nil && *in.HealthCheckVersion != *hc.HealthCheckVersion {
+		return nil, awserr.New(route53.ErrCodeHealthCheckVersionMismatch, "The value of HealthCheckVersion in the request doesn't match the value of HealthCheckVersion in the health check", nil)
+	}
+	c := hc.HealthCheckConfig
+	if in.EnableSNI != nil {
+		c.EnableSNI = in.EnableSNI
+	}
+	if in.FailureThreshold != nil {
+		c.FailureThreshold = in.FailureThreshold
+	}
+	if in.FullyQualifiedDomainName != nil {
+		c.FullyQualifiedDomainName = in.FullyQualifiedDomainName
+	}
+	if in.IPAddress != nil {
+		c.IPAddress = in.IPAddress
+	}
+	if in.Port != nil {
+		c.Port = in.Port
+	}
+	if in.ResourcePath != nil {
+		c.ResourcePath = in.ResourcePath
+	}
+	if in.SearchString != nil {
+		c.SearchString = in.SearchString
+	}
+	if in.Inverted != nil {
+		c.Inverted = in.Inverted
+	}
+	if in.Disabled != nil {
+		c.Disabled = in.Disabled
+	}
+	hc.HealthCheckVersion = aws.Int64(*hc.HealthCheckVersion + 1)
+	return &route53.UpdateHealthCheckOutput{
+		HealthCheck: awsutil.CopyOf(hc).(*route53.HealthCheck),
+	}, nil
+}
+
+func (f *Route53) DeleteHealthCheck(in *route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := aws.StringValue(in.HealthCheckId)
+	if _, ok := f.healthChecks[id]; !ok {
+		return nil, noSuchHealthCheck(id)
+	}
+	delete(f.healthChecks, id)
+	delete(f.unhealthy, id)
+	delete(f.tags, "healthcheck/"+id)
+	return &route53.DeleteHealthCheckOutput{}, nil
+}
+
+// GetHealthCheckStatus reports the status set by SetHealthCheckStatus from three checkers.
+func (f *Route53) GetHealthCheckStatus(in *route53.GetHealthCheckStatusInput) (*route53.GetHealthCheckStatusOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	id := aws.StringValue(in.HealthCheckId)
+	if _, ok := f.healthChecks[id]; !ok {
+		return nil, noSuchHealthCheck(id)
+	}
+	status := "Success: HTTP Status Code 200, OK"
+	if f.unhealthy[id] {
+		status = "Failure: Connection timed out. The endpoint or the internet connection is down, or requests are being blocked by your firewall."
+	}
+	out := &route53.GetHealthCheckStatusOutput{}
+	for _, region := range []string{"us-east-1", "eu-west-1", "ap-northeast-1"} {
+		out.HealthCheckObservations = append(out.HealthCheckObservations, &route53.HealthCheckObservation{
+			Region: aws.String(region),
+			StatusReport: &route53.StatusReport{
+				Status:      aws.String(status),
+				CheckedTime: aws.Time(time.Now()),
+			},
+		})
+	}
+	return out, nil
+}
+
+func (f *Route53) ChangeTagsForResource(in *route53.ChangeTagsForResourceInput) (*route53.ChangeTagsForResourceOutput, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	resourceType, id := aws.StringValue(in.ResourceType), aws.StringValue(in.ResourceId)
+	switch resourceType {
+	case route53.TagResourceTypeHealthcheck:
+		if _, ok := f.healthChecks[id]; !ok {
+			return nil, noSuchHealthCheck(id)
+		}
+	case route53.TagResourceTypeHostedzone:
+		if _, ok := f.zones[trimZoneID(id)]; !ok {
+			return nil, awserr.New(route53.ErrCodeNoSuchHostedZone, fmt.Sprintf("No hosted zone found with ID: %s", id), nil)
+		}
+		id = trimZoneID(id)
+	default:
+		return nil, awserr.New(route53.ErrCodeInvalidInput, fmt.Sprintf("Invalid request: unknown resource type %s", resourceType), nil)
+	}
+	key := resourceType + "/" + id
+	tags := []*route53.Tag{}
+	for _, t := range f.tags[key] {
+		if containsTagKey(in.RemoveTagKeys, *t.Key) || containsTag(in.AddTags, *t.Key) {
+			continue
+		}
+		tags = append(tags, t)
+	}
+	f.tags[key] = append(tags, in.AddTags...)
+	return &route53.ChangeTagsForResourceOutput{}, nil
+}
+
+func create(z *zone, records []*route53.ResourceRecordSet, rs *route53.ResourceRecordSet) ([]*route53.ResourceRecordSet, error) {
+	if i := indexOf(records, rs); i >= 0 {
+		return nil, invalidChangeBatch("Tried to create resource record set %s but it already exists", describe(rs))
+	}
+	if err := checkConflicts(z, records, rs); err != nil {
+		return nil, err
+	}
+	return append(records, rs), nil
+}
+
+func upsert(z *zone, records []*route53.ResourceRecordSet, rs *route53.ResourceRecordSet) ([]*route53.ResourceRecordSet, error) {
+	if i := indexOf(records, rs); i >= 0 {
+		records[i] = rs
+		return records, nil
+	}
+	if err := checkConflicts(z, records, rs); err != nil {
+		return nil, err
+	}
+	return append(records, rs), nil
+}
+
+func del(records []*route53.ResourceRecordSet, rs *route53.ResourceRecordSet) ([]*route53.ResourceRecordSet, error) {
+	i := indexOf(records, rs)
+	if i < 0 {
+		return nil, invalidChangeBatch("Tried to delete resource record set %s but it was not found", describe(rs))
+	}
+	if !recordSetEqual(records[i], rs) {
+		return nil, invalidChangeBatch("Tried to delete resource record set %s but the values provided do not match the current values", describe(rs))
+	}
+	return append(records[:i], records[i+1:]...), nil
+}
+
+// checkConflicts reports the Route53 rules for adding a new record set next to existing ones:
+// CNAME can't share a name with other types, a name/type pair is either simple or uses set identifiers,
+// failover has a single PRIMARY and SECONDARY, and each geolocation is answered by a single record set.
+func checkConflicts(z *zone, records []*route53.ResourceRecordSet, rs *route53.ResourceRecordSet) error {
+	for _, r := range records {
+		if *r.Name != *rs.Name {
+			continue
+		}
+		if *r.Type != *rs.Type && (*r.Type == route53.RRTypeCname || *rs.Type == route53.RRTypeCname) {
+			return invalidChangeBatch("RRSet of type CNAME with DNS name %s is not permitted as it conflicts with other records with the same DNS name in zone %s", *rs.Name, *z.hostedZone.Name)
+		}
+		if *r.Type == *rs.Type && (r.SetIdentifier == nil) != (rs.SetIdentifier == nil) {
+			return invalidChangeBatch("RRSet with DNS name %s, type %s cannot be created as a non-weighted set exists with the same name and type", *rs.Name, *rs.Type)
+		}
+		if *r.Type == *rs.Type && rs.SetIdentifier != nil && routingPolicy(r) != routingPolicy(rs) {
+			return invalidChangeBatch("RRSet with DNS name %s, type %s, SetIdentifier %s cannot be created because a %s record set with the same name and type exists", *rs.Name, *rs.Type, *rs.SetIdentifier, routingPolicy(r))
+		}
+		if *r.Type == *rs.Type && r.Failover != nil && aws.StringValue(r.Failover) == aws.StringValue(rs.Failover) {
+			return invalidChangeBatch("RRSet with DNS name %s, type %s, SetIdentifier %s cannot be created because a %s failover record set with the same name and type exists", *rs.Name, *rs.Type, *rs.SetIdentifier, *r.Failover)
+		}
+		if *r.Type == *rs.Type && r.GeoLocation != nil && rs.GeoLocation != nil && reflect.DeepEqual(r.GeoLocation, rs.GeoLocation) {
+			return invalidChangeBatch("RRSet with DNS name %s, type %s, SetIdentifier %s cannot be created because a record set with the same name, type and geolocation exists", *rs.Name, *rs.Type, *rs.SetIdentifier)
+		}
+	}
+	return nil
+}
+
+func validateRecordSet(z *zone, rs *route53.ResourceRecordSet) error {
+	if rs.Name == nil || rs.Type == nil {
+		return awserr.New(route53.ErrCodeInvalidInput, "Invalid request: Name and Type are required", nil)
+	}
+	zoneName := *z.hostedZone.Name
+	if *rs.Name != zoneName && !strings.HasSuffix(*rs.Name, "."+zoneName) {
+		return invalidChangeBatch("RRSet with DNS name %s is not permitted in zone %s", *rs.Name, zoneName)
+	}
+	if rs.AliasTarget != nil {
+		if rs.TTL != nil || len(rs.ResourceRecords) > 0 {
+			return awserr.New(route53.ErrCodeInvalidInput, "Invalid request: Alias record sets can't have TTL or ResourceRecords", nil)
+		}
+	} else {
+		if rs.TTL == nil || len(rs.ResourceRecords) == 0 {
+			return awserr.New(route53.ErrCodeInvalidInput, "Invalid request: Expected exactly one of [AliasTarget, all of [TTL, and ResourceRecords], or TrafficPolicyInstanceId]", nil)
+		}
+	}
+	if rs.SetIdentifier != nil && routingPolicy(rs) == "" {
+		return awserr.New(route53.ErrCodeInvalidInput, "Invalid request: A SetIdentifier requires a routing policy", nil)
+	}
+	if rs.SetIdentifier == nil && routingPolicy(rs) != "" {
+		return awserr.New(route53.ErrCodeInvalidInput, "Invalid request: A routing policy requires a SetIdentifier", nil)
+	}
+	if *rs.Type == route53.RRTypeCname && *rs.Name == zoneName {
+		return invalidChangeBatch("RRSet of type CNAME with DNS name %s is not permitted at apex in zone %s", *rs.Name, zoneName)
+	}
+	if *rs.Type == route53.RRTypeTxt {
+		for _, rr := range rs.ResourceRecords {
+			for _, str := range txtStrings(aws.StringValue(rr.Value)) {
+				if len(str) > maxTxtStringLength {
+					return invalidChangeBatch("Invalid Resource Record: FATAL problem: TXTRDATATooLong (Value is too long) encountered with '%s'", aws.StringValue(rr.Value))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// maxTxtStringLength is the limit of the length of each character string in the value of a TXT record.
+const maxTxtStringLength = 255
+
+// txtStrings returns the quoted character strings in the value of a TXT record.
+func txtStrings(value string) []string {
+	ret := []string{}
+	var b strings.Builder
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"':
+			if quoted {
+				ret = append(ret, b.String())
+				b.Reset()
+			}
+			quoted = !quoted
+		case c == '\\' && quoted && i+1 < len(value):
+			i++
+			b.WriteByte(value[i])
+		case quoted:
+			b.WriteByte(c)
+		}
+	}
+	return ret
+}
+
+func routingPolicy(rs *route53.ResourceRecordSet) string {
+	switch {
+	case rs.Weight != nil:
+		return "weighted"
+	case rs.Region != nil:
+		return "latency"
+	case rs.GeoLocation != nil:
+		return "geolocation"
+	case rs.GeoProximityLocation != nil:
+		return "geoproximity"
+	case rs.Failover != nil:
+		return "failover"
+	case rs.MultiValueAnswer != nil:
+		return "multivalue"
+	}
+	return ""
+}
+
+func recordSetEqual(a, b *route53.ResourceRecordSet) bool {
+	a, b = copyRecordSet(a), copyRecordSet(b)
+	sortResourceRecords(a)
+	sortResourceRecords(b)
+	return reflect.DeepEqual(a, b)
+}
+
+func sortResourceRecords(rs *route53.ResourceRecordSet) {
+	sort.Slice(rs.ResourceRecords, func(i, j int) bool {
+		return aws.StringValue(rs.ResourceRecords[i].Value) < aws.StringValue(rs.ResourceRecords[j].Value)
+	})
+}
+
+func indexOf(records []*route53.ResourceRecordSet, rs *route53.ResourceRecordSet) int {
+	for i, r := range records {
+		if keyOf(r) == keyOf(rs) {
+			return i
+		}
+	}
+	return -1
+}
+
+func describe(rs *route53.ResourceRecordSet) string {
+	if rs.SetIdentifier != nil {
+		return fmt.Sprintf("[name='%s', type='%s', set-identifier='%s']", *rs.Name, *rs.Type, *rs.SetIdentifier)
+	}
+	return fmt.Sprintf("[name='%s', type='%s']", *rs.Name, *rs.Type)
+}
+
+type recordKey struct {
+	name       string
+	recordType string
+	identifier string
+}
+
+func keyOf(rs *route53.ResourceRecordSet) recordKey {
+	return recordKey{
+		name:       reverseName(*rs.Name),
+		recordType: *rs.Type,
+		identifier: aws.StringValue(rs.SetIdentifier),
+	}
+}
+
+func (k recordKey) less(o recordKey) bool {
+	if k.name != o.name {
+		return k.name < o.name
+	}
+	if k.recordType != o.recordType {
+		return k.recordType < o.recordType
+	}
+	return k.identifier < o.identifier
+}
+
+// sortRecordSets orders record sets the way ListResourceRecordSets returns them:
+// by DNS name with the labels reversed, then by type, then by set identifier.
+func sortRecordSets(records []*route53.ResourceRecordSet) {
+	sort.SliceStable(records, func(i, j int) bool {
+		return keyOf(records[i]).less(keyOf(records[j]))
+	})
+}
+
+// reverseName joins the labels in reverse with NUL so that plain string
+// comparison orders names label by label.
+func reverseName(name string) string {
+	labels := strings.Split(strings.TrimSuffix(name, "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, "\x00")
+}
+
+// normalizeName returns the name as Route53 returns it: in lower case with the trailing dot,
+// and the characters other than a-z, 0-9, -, _ and . as \ooo octal escapes, e.g. * as \052.
+func normalizeName(name string) string {
+	name = strings.ToLower(unescapeName(name))
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		c := name[i]
+		if c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\%03o", c)
+		}
+	}
+	return b.String()
+}
+
+// unescapeName replaces the \ooo octal escapes of the name, Route53 accepts both escaped and unescaped names.
+func unescapeName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+4 <= len(name) {
+			if c, err := strconv.ParseUint(name[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+func normalizeRecordSet(rs *route53.ResourceRecordSet) {
+	if rs.Name != nil {
+		rs.Name = aws.String(normalizeName(*rs.Name))
+	}
+	if rs.AliasTarget != nil && rs.AliasTarget.DNSName != nil {
+		rs.AliasTarget.DNSName = aws.String(normalizeName(*rs.AliasTarget.DNSName))
+	}
+	if rs.AliasTarget != nil && rs.AliasTarget.HostedZoneId != nil {
+		rs.AliasTarget.HostedZoneId = aws.String(trimZoneID(*rs.AliasTarget.HostedZoneId))
+	}
+}
+
+func trimZoneID(id string) string {
+	return strings.TrimPrefix(id, "/hostedzone/")
+}
+
+func copyRecordSet(rs *route53.ResourceRecordSet) *route53.ResourceRecordSet {
+	return awsutil.CopyOf(rs).(*route53.ResourceRecordSet)
+}
+
+func containsTagKey(keys []*string, key string) bool {
+	for _, k := range keys {
+		if aws.StringValue(k) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func containsTag(tags []*route53.Tag, key string) bool {
+	for _, t := range tags {
+		if aws.StringValue(t.Key) == key {
+			return true
+		}
+	}
+	return false
+}
+
+func invalidChangeBatch(format string, a ...interface{}) error {
+	return awserr.New(route53.ErrCodeInvalidChangeBatch, "["+fmt.Sprintf(format, a...)+"]", nil)
+}
+
+func noSuchHealthCheck(id string) error {
+	return awserr.New(route53.ErrCodeNoSuchHealthCheck, fmt.Sprintf("A health check with id %s does not exist.", id), nil)
+}
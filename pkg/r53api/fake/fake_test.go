@@ -0,0 +1,320 @@
+package fake
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+func weighted(name, identifier, ip string, weight int64) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            aws.String("A"),
+		SetIdentifier:   aws.String(identifier),
+		Weight:          aws.Int64(weight),
+		TTL:             aws.Int64(10),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+	}
+}
+
+func failover(name, identifier, ip, failover string) *route53.ResourceRecordSet {
+	return &route53.ResourceRecordSet{
+		Name:            aws.String(name),
+		Type:            aws.String("A"),
+		SetIdentifier:   aws.String(identifier),
+		Failover:        aws.String(failover),
+		TTL:             aws.Int64(10),
+		ResourceRecords: []*route53.ResourceRecord{{Value: aws.String(ip)}},
+	}
+}
+
+func change(f *Route53, action string, rss ...*route53.ResourceRecordSet) error {
+	changes := []*route53.Change{}
+	for _, rs := range rss {
+		changes = append(changes, &route53.Change{Action: aws.String(action), ResourceRecordSet: rs})
+	}
+	_, err := f.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String("/hostedzone/Z1"),
+		ChangeBatch:  &route53.ChangeBatch{Changes: changes},
+	})
+	return err
+}
+
+func TestChangeResourceRecordSets(t *testing.T) {
+	tests := []struct {
+		name    string
+		seed    []*route53.ResourceRecordSet
+		action  string
+		rs      []*route53.ResourceRecordSet
+		wantErr string
+		want    int
+	}{
+		{
+			name:   "create",
+			action: "CREATE",
+			rs:     []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			want:   1,
+		},
+		{
+			name:    "create-existing",
+			seed:    []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action:  "CREATE",
+			rs:      []*route53.ResourceRecordSet{weighted("a.example.com.", "1", "10.0.0.2", 1)},
+			wantErr: "but it already exists",
+			want:    1,
+		},
+		{
+			name:   "upsert-other-identifier",
+			seed:   []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action: "UPSERT",
+			rs:     []*route53.ResourceRecordSet{weighted("a.example.com", "2", "10.0.0.2", 1)},
+			want:   2,
+		},
+		{
+			name:   "upsert-replace",
+			seed:   []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action: "UPSERT",
+			rs:     []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.2", 5)},
+			want:   1,
+		},
+		{
+			name:   "delete-exact",
+			seed:   []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action: "DELETE",
+			rs:     []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			want:   0,
+		},
+		{
+			name:    "delete-mismatch",
+			seed:    []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action:  "DELETE",
+			rs:      []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 2)},
+			wantErr: "do not match the current values",
+			want:    1,
+		},
+		{
+			name:    "delete-missing",
+			action:  "DELETE",
+			rs:      []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			wantErr: "but it was not found",
+		},
+		{
+			name:   "batch-is-atomic",
+			seed:   []*route53.ResourceRecordSet{weighted("a.example.com", "1", "10.0.0.1", 1)},
+			action: "CREATE",
+			rs: []*route53.ResourceRecordSet{
+				weighted("b.example.com", "1", "10.0.0.2", 1),
+				weighted("a.example.com", "1", "10.0.0.1", 1),
+			},
+			wantErr: "but it already exists",
+			want:    1,
+		},
+		{
+			name:    "second-primary",
+			seed:    []*route53.ResourceRecordSet{failover("a.example.com", "1", "10.0.0.1", "PRIMARY")},
+			action:  "CREATE",
+			rs:      []*route53.ResourceRecordSet{failover("a.example.com", "2", "10.0.0.2", "PRIMARY")},
+			wantErr: "PRIMARY failover record set",
+			want:    1,
+		},
+		{
+			name:   "primary-and-secondary",
+			seed:   []*route53.ResourceRecordSet{failover("a.example.com", "1", "10.0.0.1", "PRIMARY")},
+			action: "CREATE",
+			rs:     []*route53.ResourceRecordSet{failover("a.example.com", "2", "10.0.0.2", "SECONDARY")},
+			want:   2,
+		},
+		{
+			name:    "outside-zone",
+			action:  "CREATE",
+			rs:      []*route53.ResourceRecordSet{weighted("a.example.org", "1", "10.0.0.1", 1)},
+			wantErr: "is not permitted in zone",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := New()
+			f.AddHostedZone("Z1", "example.com", false)
+			if len(tt.seed) > 0 {
+				if err := change(f, "CREATE", tt.seed...); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := change(f, tt.action, tt.rs...)
+			if (err != nil) != (tt.wantErr != "") || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("ChangeResourceRecordSets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(f.RecordSets("Z1")); got != tt.want {
+				t.Errorf("ChangeResourceRecordSets() records = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListResourceRecordSets(t *testing.T) {
+	f := New()
+	f.AddHostedZone("Z1", "example.com", false)
+	err := change(f, "CREATE",
+		weighted("b.example.com", "2", "10.0.0.1", 1),
+		weighted("a.example.com", "1", "10.0.0.1", 1),
+		weighted("b.example.com", "1", "10.0.0.1", 1),
+		weighted("a.b.example.com", "1", "10.0.0.1", 1),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// labels are compared in reverse, so a.b.example.com sorts right after b.example.com
+	want := []string{"a.example.com./1", "b.example.com./1", "b.example.com./2", "a.b.example.com./1"}
+	got := []string{}
+	in := &route53.ListResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z1"),
+		MaxItems:     aws.String("3"),
+	}
+	for {
+		out, err := f.ListResourceRecordSets(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rs := range out.ResourceRecordSets {
+			got = append(got, *rs.Name+"/"+*rs.SetIdentifier)
+		}
+		if !*out.IsTruncated {
+			break
+		}
+		in.StartRecordName = out.NextRecordName
+		in.StartRecordType = out.NextRecordType
+		in.StartRecordIdentifier = out.NextRecordIdentifier
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("ListResourceRecordSets() = %v, want %v", got, want)
+	}
+
+	out, err := f.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String("Z1"),
+		StartRecordName: aws.String("b.example.com"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ResourceRecordSets) != 3 || *out.ResourceRecordSets[0].Name != "b.example.com." {
+		t.Errorf("ListResourceRecordSets() from b.example.com = %v", out.ResourceRecordSets)
+	}
+}
+
+func TestListResourceRecordSetsEscapesNames(t *testing.T) {
+	f := New()
+	f.AddHostedZone("Z1", "example.com", false)
+	if err := change(f, "CREATE", weighted("*.Apps.example.com", "1", "10.0.0.1", 1)); err != nil {
+		t.Fatal(err)
+	}
+	// the escaped name addresses the same record set
+	if err := change(f, "UPSERT", weighted("\\052.apps.example.com.", "1", "10.0.0.2", 1)); err != nil {
+		t.Fatal(err)
+	}
+	out, err := f.ListResourceRecordSets(&route53.ListResourceRecordSetsInput{HostedZoneId: aws.String("Z1")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ResourceRecordSets) != 1 || *out.ResourceRecordSets[0].Name != "\\052.apps.example.com." {
+		t.Errorf("ListResourceRecordSets() = %v, want the wildcard escaped", out.ResourceRecordSets)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	f := New()
+	config := &route53.HealthCheckConfig{
+		Type:      aws.String("TCP"),
+		IPAddress: aws.String("10.0.0.1"),
+		Port:      aws.Int64(80),
+	}
+	out, err := f.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		CallerReference:   aws.String("ref"),
+		HealthCheckConfig: config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := *out.HealthCheck.Id
+	again, err := f.CreateHealthCheck(&route53.CreateHealthCheckInput{
+		CallerReference:   aws.String("ref"),
+		HealthCheckConfig: config,
+	})
+	if err != nil || *again.HealthCheck.Id != id {
+		t.Errorf("CreateHealthCheck() is not idempotent: %v, %v", again, err)
+	}
+	if _, err := f.UpdateHealthCheck(&route53.UpdateHealthCheckInput{
+		HealthCheckId: aws.String(id),
+		Port:          aws.Int64(443),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if hc, _ := f.HealthCheck(id); *hc.HealthCheckConfig.Port != 443 || *hc.HealthCheckVersion != 2 {
+		t.Errorf("UpdateHealthCheck() = %v", hc)
+	}
+	if _, err := f.DeleteHealthCheck(&route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.DeleteHealthCheck(&route53.DeleteHealthCheckInput{HealthCheckId: aws.String(id)}); err == nil {
+		t.Errorf("DeleteHealthCheck() of a deleted health check should fail")
+	}
+}
+
+func TestListHostedZones(t *testing.T) {
+	f := New()
+	f.AddHostedZone("Z2", "example.org", true)
+	f.AddHostedZone("Z1", "example.com", false)
+	f.AddHostedZone("Z3", "example.net", false)
+	got := []string{}
+	in := &route53.ListHostedZonesInput{MaxItems: aws.String("2")}
+	for {
+		out, err := f.ListHostedZones(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, hz := range out.HostedZones {
+			got = append(got, *hz.Id+"/"+*hz.Name)
+		}
+		if !*out.IsTruncated {
+			break
+		}
+		in.Marker = out.NextMarker
+	}
+	want := "/hostedzone/Z1/example.com.,/hostedzone/Z2/example.org.,/hostedzone/Z3/example.net."
+	if strings.Join(got, ",") != want {
+		t.Errorf("ListHostedZones() = %v, want %v", got, want)
+	}
+}
+
+func TestGetChange(t *testing.T) {
+	f := New()
+	f.AddHostedZone("Z1", "example.com", false)
+	out, err := f.ChangeResourceRecordSets(&route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String("Z1"),
+		ChangeBatch: &route53.ChangeBatch{Changes: []*route53.Change{
+			{Action: aws.String("CREATE"), ResourceRecordSet: weighted("www.example.com", "a", "10.0.0.1", 1)},
+		}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := func(id string) string {
+		got, err := f.GetChange(&route53.GetChangeInput{Id: aws.String(id)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return *got.ChangeInfo.Status
+	}
+	id := strings.TrimPrefix(*out.ChangeInfo.Id, "/change/")
+	if got := status(id); got != route53.ChangeStatusPending {
+		t.Errorf("GetChange() status = %v, want %v", got, route53.ChangeStatusPending)
+	}
+	f.SyncChanges()
+	if got := status(id); got != route53.ChangeStatusInsync {
+		t.Errorf("GetChange() status = %v after SyncChanges(), want %v", got, route53.ChangeStatusInsync)
+	}
+	if _, err := f.GetChange(&route53.GetChangeInput{Id: aws.String("missing")}); err == nil {
+		t.Errorf("GetChange() of a missing change succeeded")
+	}
+}
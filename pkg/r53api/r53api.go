@@ -0,0 +1,30 @@
+package r53api
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/route53"
+)
+
+// API is the subset of the Route53 client used by external-route53.
+// *route53.Route53 satisfies it, and fake.Route53 implements it in memory for tests.
+type API interface {
+	ChangeResourceRecordSets(*route53.ChangeResourceRecordSetsInput) (*route53.ChangeResourceRecordSetsOutput, error)
+	ListResourceRecordSets(*route53.ListResourceRecordSetsInput) (*route53.ListResourceRecordSetsOutput, error)
+	GetHostedZone(*route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error)
+	ListHostedZones(*route53.ListHostedZonesInput) (*route53.ListHostedZonesOutput, error)
+	CreateHealthCheck(*route53.CreateHealthCheckInput) (*route53.CreateHealthCheckOutput, error)
+	UpdateHealthCheck(*route53.UpdateHealthCheckInput) (*route53.UpdateHealthCheckOutput, error)
+	DeleteHealthCheck(*route53.DeleteHealthCheckInput) (*route53.DeleteHealthCheckOutput, error)
+	GetHealthCheckStatus(*route53.GetHealthCheckStatusInput) (*route53.GetHealthCheckStatusOutput, error)
+	ChangeTagsForResource(*route53.ChangeTagsForResourceInput) (*route53.ChangeTagsForResourceOutput, error)
+	GetChange(*route53.GetChangeInput) (*route53.GetChangeOutput, error)
+}
+
+// New returns a Route53 client built from the default AWS session.
+// The requests are rate limited and retried by WithRetry instead of the retries of the SDK,
+// so that the retries are rate limited as well.
+func New() API {
+	mySession := session.Must(session.NewSession())
+	return WithRetry(route53.New(mySession, aws.NewConfig().WithMaxRetries(0)), DefaultRetryOptions)
+}
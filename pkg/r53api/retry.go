@@ -0,0 +1,187 @@
+package r53api
+
+import (
+	"math/rand"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/metrics"
+	"k8s.io/client-go/util/flowcontrol"
+)
+
+// RetryOptions configures the rate limit and the retries of the requests to Route53.
+type RetryOptions struct {
+	// RequestsPerSecond is the rate of the token bucket, Route53 allows 5 requests per second per account
+	RequestsPerSecond float32
+	Burst             int
+	// MaxRetries is how many times a throttled or failed request is retried
+	MaxRetries int
+	// BaseDelay is the longest wait before the first retry, doubled for each retry up to MaxDelay
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// DefaultRetryOptions keeps the requests within the limit of Route53 per account.
+var DefaultRetryOptions = RetryOptions{
+	RequestsPerSecond: 5,
+	Burst:             5,
+	MaxRetries:        5,
+	BaseDelay:         200 * time.Millisecond,
+	MaxDelay:          10 * time.Second,
+}
+
+type retryingAPI struct {
+	api     API
+	opts    RetryOptions
+	limiter flowcontrol.RateLimiter
+}
+
+// WithRetry returns an API sending the requests to api within the rate of opts,
+// and retrying the requests throttled by Route53 or failed by a transient error with exponential backoff and jitter.
+func WithRetry(api API, opts RetryOptions) API {
+	return &retryingAPI{
+		api:     api,
+		opts:    opts,
+		limiter: flowcontrol.NewTokenBucketRateLimiter(opts.RequestsPerSecond, opts.Burst),
+	}
+}
+
+func (r *retryingAPI) do(operation string, f func() error) error {
+	for retries := 0; ; retries++ {
+		r.limiter.Accept()
+		err := f()
+		if !retryable(err) {
+			return err
+		}
+		if throttled(err) {
+			metrics.Route53ThrottledRequests.WithLabelValues(operation, err.(awserr.Error).Code()).Inc()
+		}
+		if retries >= r.opts.MaxRetries {
+			return err
+		}
+		time.Sleep(r.backoff(retries))
+	}
+}
+
+// backoff returns a random wait up to BaseDelay doubled for each retry, within MaxDelay.
+func (r *retryingAPI) backoff(retries int) time.Duration {
+	d := r.opts.BaseDelay
+	for i := 0; i < retries && d < r.opts.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > r.opts.MaxDelay {
+		d = r.opts.MaxDelay
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// retryable reports whether err is worth retrying: the throttling of Route53, a change to the same records in progress,
+// or a failure the retryer of the SDK would retry, e.g. a 5xx response, a timeout or a reset connection.
+func retryable(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	if throttled(err) {
+		return true
+	}
+	// 501 Not Implemented fails the same way again
+	if rerr, ok := err.(awserr.RequestFailure); ok && rerr.StatusCode() >= 500 && rerr.StatusCode() != 501 {
+		return true
+	}
+	return request.IsErrorRetryable(aerr)
+}
+
+// throttled reports whether err is the throttling of Route53 or a change to the same records in progress.
+func throttled(err error) bool {
+	aerr, ok := err.(awserr.Error)
+	if !ok {
+		return false
+	}
+	return request.IsErrorThrottle(err) || aerr.Code() == route53.ErrCodePriorRequestNotComplete
+}
+
+func (r *retryingAPI) ChangeResourceRecordSets(in *route53.ChangeResourceRecordSetsInput) (out *route53.ChangeResourceRecordSetsOutput, err error) {
+	err = r.do("ChangeResourceRecordSets", func() error {
+		out, err = r.api.ChangeResourceRecordSets(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) ListResourceRecordSets(in *route53.ListResourceRecordSetsInput) (out *route53.ListResourceRecordSetsOutput, err error) {
+	err = r.do("ListResourceRecordSets", func() error {
+		out, err = r.api.ListResourceRecordSets(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) GetHostedZone(in *route53.GetHostedZoneInput) (out *route53.GetHostedZoneOutput, err error) {
+	err = r.do("GetHostedZone", func() error {
+		out, err = r.api.GetHostedZone(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) ListHostedZones(in *route53.ListHostedZonesInput) (out *route53.ListHostedZonesOutput, err error) {
+	err = r.do("ListHostedZones", func() error {
+		out, err = r.api.ListHostedZones(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) CreateHealthCheck(in *route53.CreateHealthCheckInput) (out *route53.CreateHealthCheckOutput, err error) {
+	err = r.do("CreateHealthCheck", func() error {
+		out, err = r.api.CreateHealthCheck(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) UpdateHealthCheck(in *route53.UpdateHealthCheckInput) (out *route53.UpdateHealthCheckOutput, err error) {
+	err = r.do("UpdateHealthCheck", func() error {
+		out, err = r.api.UpdateHealthCheck(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) DeleteHealthCheck(in *route53.DeleteHealthCheckInput) (out *route53.DeleteHealthCheckOutput, err error) {
+	err = r.do("DeleteHealthCheck", func() error {
+		out, err = r.api.DeleteHealthCheck(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) GetHealthCheckStatus(in *route53.GetHealthCheckStatusInput) (out *route53.GetHealthCheckStatusOutput, err error) {
+	err = r.do("GetHealthCheckStatus", func() error {
+		out, err = r.api.GetHealthCheckStatus(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) ChangeTagsForResource(in *route53.ChangeTagsForResourceInput) (out *route53.ChangeTagsForResourceOutput, err error) {
+	err = r.do("ChangeTagsForResource", func() error {
+		out, err = r.api.ChangeTagsForResource(in)
+		return err
+	})
+	return out, err
+}
+
+func (r *retryingAPI) GetChange(in *route53.GetChangeInput) (out *route53.GetChangeOutput, err error) {
+	err = r.do("GetChange", func() error {
+		out, err = r.api.GetChange(in)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,116 @@
+package r53api_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/route53"
+	"github.com/takutakahashi/external-route53/pkg/r53api"
+	"github.com/takutakahashi/external-route53/pkg/r53api/fake"
+)
+
+// flakyAPI fails the requests with errs before passing them to the fake.
+type flakyAPI struct {
+	*fake.Route53
+	errs  []error
+	calls int
+}
+
+func (f *flakyAPI) GetHostedZone(in *route53.GetHostedZoneInput) (*route53.GetHostedZoneOutput, error) {
+	f.calls++
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, err
+	}
+	return f.Route53.GetHostedZone(in)
+}
+
+func TestWithRetry(t *testing.T) {
+	throttling := awserr.New("Throttling", "Rate exceeded", nil)
+	inProgress := awserr.New(route53.ErrCodePriorRequestNotComplete, "The request was rejected because Route 53 was still processing a prior request.", nil)
+	noSuchZone := awserr.New(route53.ErrCodeNoSuchHostedZone, "No hosted zone found with ID: test", nil)
+	internalError := awserr.NewRequestFailure(awserr.New("InternalFailure", "An internal error occurred.", nil), 500, "id")
+	serviceUnavailable := awserr.NewRequestFailure(awserr.New("ServiceUnavailable", "Service Unavailable", nil), 503, "id")
+	notImplemented := awserr.NewRequestFailure(awserr.New("NotImplemented", "Not Implemented", nil), 501, "id")
+	connectionReset := awserr.New(request.ErrCodeRequestError, "send request failed", errors.New("write: connection reset by peer"))
+	tests := []struct {
+		name      string
+		errs      []error
+		wantCalls int
+		wantErr   bool
+	}{
+		{
+			name:      "ok",
+			wantCalls: 1,
+		},
+		{
+			name:      "throttled",
+			errs:      []error{throttling, throttling},
+			wantCalls: 3,
+		},
+		{
+			name:      "prior-request-not-complete",
+			errs:      []error{inProgress},
+			wantCalls: 2,
+		},
+		{
+			name:      "retries-exhausted",
+			errs:      []error{throttling, throttling, throttling, throttling},
+			wantCalls: 3,
+			wantErr:   true,
+		},
+		{
+			name:      "server-error",
+			errs:      []error{internalError, serviceUnavailable},
+			wantCalls: 3,
+		},
+		{
+			name:      "connection-reset",
+			errs:      []error{connectionReset},
+			wantCalls: 2,
+		},
+		{
+			name:      "not-implemented",
+			errs:      []error{notImplemented},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "not-retryable",
+			errs:      []error{noSuchZone},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+		{
+			name:      "not-aws-error",
+			errs:      []error{errors.New("connection refused")},
+			wantCalls: 1,
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &flakyAPI{Route53: fake.New(), errs: tt.errs}
+			f.AddHostedZone("test", "example.com", false)
+			api := r53api.WithRetry(f, r53api.RetryOptions{
+				RequestsPerSecond: 100,
+				Burst:             10,
+				MaxRetries:        2,
+				BaseDelay:         time.Millisecond,
+				MaxDelay:          5 * time.Millisecond,
+			})
+			_, err := api.GetHostedZone(&route53.GetHostedZoneInput{Id: aws.String("test")})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("GetHostedZone() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if f.calls != tt.wantCalls {
+				t.Errorf("GetHostedZone() is called %d times, want %d", f.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package trafficshift
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	"github.com/takutakahashi/external-route53/pkg/condition"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// maxWeight is the largest weight of a Route53 weighted record.
+const maxWeight = 255
+
+// Validate checks the spec of the traffic shift.
+func Validate(ts *route53v1.TrafficShift) error {
+	if len(ts.Spec.Services) < 2 {
+		return errors.New("at least two services are required")
+	}
+	seen := map[string]bool{}
+	for _, s := range ts.Spec.Services {
+		if s.Name == "" {
+			return errors.New("service name is not found")
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("service %s is specified twice", s.Name)
+		}
+		seen[s.Name] = true
+		if s.Weight < 0 || s.Weight > maxWeight {
+			return fmt.Errorf("weight of service %s must be between 0 and %d", s.Name, maxWeight)
+		}
+	}
+	if ts.Spec.StepPercent < 1 || ts.Spec.StepPercent > 100 {
+		return errors.New("stepPercent must be between 1 and 100")
+	}
+	if ts.Spec.Interval.Duration <= 0 {
+		return errors.New("interval must be over 0s")
+	}
+	switch ts.Spec.OnUnhealthy {
+	case "", route53v1.UnhealthyPolicyPause, route53v1.UnhealthyPolicyRollback:
+	default:
+		return fmt.Errorf("onUnhealthy must be Pause or Rollback: %s", ts.Spec.OnUnhealthy)
+	}
+	return nil
+}
+
+// Next advances the traffic shift at now and returns the time to wait before calling it again, 0 when it's over.
+// current are the weights set to the services, used as the initial weights when the spec changes.
+// healthy reports whether every health check attached to the services is healthy.
+func Next(ts *route53v1.TrafficShift, current map[string]int, healthy bool, now time.Time) time.Duration {
+	if ts.Status.ObservedGeneration != ts.Generation {
+		// the spec changed, shift from the weights currently set
+		weights := []route53v1.TrafficShiftWeight{}
+		for _, s := range ts.Spec.Services {
+			weights = append(weights, route53v1.TrafficShiftWeight{Name: s.Name, Weight: current[s.Name]})
+		}
+		ts.Status = route53v1.TrafficShiftStatus{
+			Phase:              route53v1.PhaseProgressing,
+			Weights:            weights,
+			InitialWeights:     append([]route53v1.TrafficShiftWeight{}, weights...),
+			ObservedGeneration: ts.Generation,
+			Conditions:         ts.Status.Conditions,
+		}
+	}
+	if ts.Status.Phase == route53v1.PhaseCompleted || ts.Status.Phase == route53v1.PhaseRolledBack {
+		return 0
+	}
+	if !healthy {
+		if ts.Spec.OnUnhealthy == route53v1.UnhealthyPolicyRollback {
+			ts.Status.Phase = route53v1.PhaseRolledBack
+			ts.Status.Weights = append([]route53v1.TrafficShiftWeight{}, ts.Status.InitialWeights...)
+			ts.Status.LastStepTime = &metav1.Time{Time: now}
+			ts.Status.Message = "rolled back as a health check is unhealthy"
+			return 0
+		}
+		ts.Status.Phase = route53v1.PhasePaused
+		ts.Status.Message = "paused as a health check is unhealthy"
+		return ts.Spec.Interval.Duration
+	}
+	ts.Status.Phase = route53v1.PhaseProgressing
+	ts.Status.Message = ""
+	if ts.Status.LastStepTime != nil {
+		if wait := ts.Status.LastStepTime.Add(ts.Spec.Interval.Duration).Sub(now); wait > 0 {
+			return wait
+		}
+	}
+	done := true
+	stepWeight := stepWeight(ts)
+	for i, s := range ts.Spec.Services {
+		w := step(weightOf(ts.Status.Weights, s.Name), s.Weight, stepWeight)
+		ts.Status.Weights[i] = route53v1.TrafficShiftWeight{Name: s.Name, Weight: w}
+		done = done && w == s.Weight
+	}
+	ts.Status.Step++
+	ts.Status.LastStepTime = &metav1.Time{Time: now}
+	if done {
+		ts.Status.Phase = route53v1.PhaseCompleted
+		return 0
+	}
+	return ts.Spec.Interval.Duration
+}
+
+// stepWeight returns StepPercent of the sum of the weights of the services, at least 1.
+// The larger sum of the initial and the target weights is used, so that a shift to or from all zero weights moves as well.
+func stepWeight(ts *route53v1.TrafficShift) int {
+	initial, target := 0, 0
+	for _, s := range ts.Spec.Services {
+		initial += weightOf(ts.Status.InitialWeights, s.Name)
+		target += s.Weight
+	}
+	total := target
+	if initial > total {
+		total = initial
+	}
+	// rounded up, a step of a small percent of small weights still moves them
+	ret := (total*ts.Spec.StepPercent + 99) / 100
+	if ret < 1 {
+		return 1
+	}
+	return ret
+}
+
+// SetCondition sets the status and the message of the condition, its transition time changes with the status.
+func SetCondition(ts *route53v1.TrafficShift, conditionType, status, message string, now time.Time) {
+	c, _, err := condition.GetTypedCondition(ts.Status.Conditions, conditionType)
+	if err != nil {
+		ts.Status.Conditions = append(ts.Status.Conditions, condition.Condition{
+			Type:               conditionType,
+			Status:             status,
+			Message:            message,
+			LastTransitionTime: now,
+			LastUpdateTime:     now,
+		})
+		return
+	}
+	if c.Status == status && c.Message == message {
+		return
+	}
+	if c.Status != status {
+		ts.Status.Conditions, _ = condition.Transition(ts.Status.Conditions, conditionType, &now)
+	}
+	ts.Status.Conditions, _ = condition.Update(ts.Status.Conditions, conditionType, &message, &status, &now)
+}
+
+// step moves the weight toward target by stepWeight at most.
+func step(weight, target, stepWeight int) int {
+	switch {
+	case target-weight > stepWeight:
+		return weight + stepWeight
+	case weight-target > stepWeight:
+		return weight - stepWeight
+	}
+	return target
+}
+
+func weightOf(weights []route53v1.TrafficShiftWeight, name string) int {
+	for _, w := range weights {
+		if w.Name == name {
+			return w.Weight
+		}
+	}
+	return 0
+}
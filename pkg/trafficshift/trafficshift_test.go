@@ -0,0 +1,219 @@
+package trafficshift
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	route53v1 "github.com/takutakahashi/external-route53/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func newTrafficShift(onUnhealthy route53v1.TrafficShiftUnhealthyPolicy) *route53v1.TrafficShift {
+	return &route53v1.TrafficShift{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       "test",
+			Namespace:  "test",
+			Generation: 1,
+		},
+		Spec: route53v1.TrafficShiftSpec{
+			Services: []route53v1.TrafficShiftWeight{
+				{Name: "stable", Weight: 0},
+				{Name: "canary", Weight: 100},
+			},
+			StepPercent: 40,
+			Interval:    metav1.Duration{Duration: 5 * time.Minute},
+			OnUnhealthy: onUnhealthy,
+		},
+	}
+}
+
+func weights(ts *route53v1.TrafficShift) []int {
+	ret := []int{}
+	for _, w := range ts.Status.Weights {
+		ret = append(ret, w.Weight)
+	}
+	return ret
+}
+
+func TestNext(t *testing.T) {
+	current := map[string]int{"stable": 100, "canary": 0}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		onUnhealthy route53v1.TrafficShiftUnhealthyPolicy
+		// health of the health checks at each call, 5 minutes apart
+		healthy   []bool
+		want      []int
+		wantPhase route53v1.TrafficShiftPhase
+		wantStep  int
+	}{
+		{
+			name:      "first-step",
+			healthy:   []bool{true},
+			want:      []int{60, 40},
+			wantPhase: route53v1.PhaseProgressing,
+			wantStep:  1,
+		},
+		{
+			name:      "completed",
+			healthy:   []bool{true, true, true, true},
+			want:      []int{0, 100},
+			wantPhase: route53v1.PhaseCompleted,
+			wantStep:  3,
+		},
+		{
+			name:      "pause",
+			healthy:   []bool{true, false},
+			want:      []int{60, 40},
+			wantPhase: route53v1.PhasePaused,
+			wantStep:  1,
+		},
+		{
+			name:      "resume",
+			healthy:   []bool{true, false, true},
+			want:      []int{20, 80},
+			wantPhase: route53v1.PhaseProgressing,
+			wantStep:  2,
+		},
+		{
+			name:        "rollback",
+			onUnhealthy: route53v1.UnhealthyPolicyRollback,
+			healthy:     []bool{true, true, false, true},
+			want:        []int{100, 0},
+			wantPhase:   route53v1.PhaseRolledBack,
+			wantStep:    2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTrafficShift(tt.onUnhealthy)
+			for i, healthy := range tt.healthy {
+				Next(ts, current, healthy, now.Add(time.Duration(i)*5*time.Minute))
+			}
+			if got := weights(ts); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next() weights = %v, want %v", got, tt.want)
+			}
+			if ts.Status.Phase != tt.wantPhase {
+				t.Errorf("Next() phase = %v, want %v", ts.Status.Phase, tt.wantPhase)
+			}
+			if ts.Status.Step != tt.wantStep {
+				t.Errorf("Next() step = %v, want %v", ts.Status.Step, tt.wantStep)
+			}
+		})
+	}
+}
+
+func TestNextWaitsForInterval(t *testing.T) {
+	ts := newTrafficShift("")
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	current := map[string]int{"stable": 100, "canary": 0}
+	if after := Next(ts, current, true, now); after != 5*time.Minute {
+		t.Errorf("Next() = %v, want %v", after, 5*time.Minute)
+	}
+	if after := Next(ts, current, true, now.Add(time.Minute)); after != 4*time.Minute {
+		t.Errorf("Next() = %v, want %v", after, 4*time.Minute)
+	}
+	if got := weights(ts); !reflect.DeepEqual(got, []int{60, 40}) {
+		t.Errorf("Next() weights = %v before the interval", got)
+	}
+	// a new spec starts over from the current weights
+	ts.Generation++
+	Next(ts, map[string]int{"stable": 60, "canary": 40}, true, now.Add(2*time.Minute))
+	if ts.Status.Step != 1 || ts.Status.InitialWeights[0].Weight != 60 {
+		t.Errorf("Next() status = %+v after the spec changed", ts.Status)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(ts *route53v1.TrafficShift)
+		wantErr bool
+	}{
+		{
+			name:   "ok",
+			modify: func(ts *route53v1.TrafficShift) {},
+		},
+		{
+			name: "single-service",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.Services = ts.Spec.Services[:1]
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicated-service",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.Services[1].Name = "stable"
+			},
+			wantErr: true,
+		},
+		{
+			name: "weight-over-255",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.Services[1].Weight = 256
+			},
+			wantErr: true,
+		},
+		{
+			name: "no-step-percent",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.StepPercent = 0
+			},
+			wantErr: true,
+		},
+		{
+			name: "step-percent-over-100",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.StepPercent = 101
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown-policy",
+			modify: func(ts *route53v1.TrafficShift) {
+				ts.Spec.OnUnhealthy = "Ignore"
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTrafficShift("")
+			tt.modify(ts)
+			if err := Validate(ts); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_stepWeight(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial []int
+		target  []int
+		percent int
+		want    int
+	}{
+		{name: "percent", initial: []int{100, 0}, target: []int{0, 100}, percent: 10, want: 10},
+		{name: "rounded-up", initial: []int{255, 0}, target: []int{0, 255}, percent: 10, want: 26},
+		{name: "from-default-weights", initial: []int{1, 1}, target: []int{0, 100}, percent: 25, want: 25},
+		{name: "to-zero-weights", initial: []int{100, 0}, target: []int{0, 0}, percent: 50, want: 50},
+		{name: "at-least-1", initial: []int{1, 0}, target: []int{0, 1}, percent: 1, want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := newTrafficShift("")
+			ts.Spec.StepPercent = tt.percent
+			for i := range ts.Spec.Services {
+				ts.Spec.Services[i].Weight = tt.target[i]
+				ts.Status.InitialWeights = append(ts.Status.InitialWeights, route53v1.TrafficShiftWeight{Name: ts.Spec.Services[i].Name, Weight: tt.initial[i]})
+			}
+			if got := stepWeight(ts); got != tt.want {
+				t.Errorf("stepWeight() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}